@@ -7,11 +7,10 @@ require (
 	github.com/andybalholm/brotli v1.0.4 // indirect
 	github.com/casbin/casbin/v2 v2.39.1
 	github.com/fastly/go-utils v0.0.0-20180712184237-d95a45783239 // indirect
-	github.com/forgoer/openssl v1.2.1 // indirect
+	github.com/forgoer/openssl v1.2.1
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/go-stomp/stomp/v3 v3.0.3
 	github.com/gofiber/fiber/v2 v2.23.0
-	github.com/gofiber/jwt/v2 v2.2.7
 	github.com/gofiber/template v1.6.20
 	github.com/golang-jwt/jwt/v4 v4.1.0
 	github.com/gomodule/redigo v1.8.5
@@ -21,7 +20,7 @@ require (
 	github.com/lestrrat/go-file-rotatelogs v0.0.0-20180223000712-d3151e2a480f
 	github.com/lestrrat/go-strftime v0.0.0-20180220042222-ba3bf9c1d042 // indirect
 	github.com/lib/pq v1.10.4
-	github.com/rabbitmq/amqp091-go v1.3.4 // indirect
+	github.com/rabbitmq/amqp091-go v1.3.4
 	github.com/rifflock/lfshook v0.0.0-20180920164130-b9218ef580f5
 	github.com/robfig/cron/v3 v3.0.1
 	github.com/rs/xid v1.2.1
@@ -30,6 +29,9 @@ require (
 	github.com/spf13/viper v1.9.0
 	github.com/tebeka/strftime v0.1.5 // indirect
 	github.com/tjfoc/gmsm v1.4.1
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
 	golang.org/x/sys v0.0.0-20211205182925-97ca703d548d // indirect
+	modernc.org/sqlite v1.11.2
+	xorm.io/builder v0.3.9
 	xorm.io/xorm v1.2.5
 )
@@ -0,0 +1,133 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/yockii/qscore/pkg/constant"
+)
+
+// Error 统一错误类型，携带错误码、HTTP状态码、消息键及原始错误
+// 由 server.ErrorHandler 转换为 domain.CommonResponse
+type Error struct {
+	Code   int
+	Status int
+	MsgKey string
+	Args   []interface{}
+	Cause  error
+	// Data 需要返回给调用方的附加信息，如校验失败的字段列表
+	Data interface{}
+}
+
+// Localizer Data实现该接口时，ErrorHandler 按请求语言调用 Localize 生成返回内容
+type Localizer interface {
+	Localize(lang string) interface{}
+}
+
+var (
+	ErrUnknown      = New(constant.ErrorCodeUnknown, http.StatusInternalServerError, "error.unknown")
+	ErrBodyParse    = New(constant.ErrorCodeBodyParse, http.StatusBadRequest, "error.bodyParse")
+	ErrLackOfField  = New(constant.ErrorCodeLackOfField, http.StatusBadRequest, "error.lackOfField")
+	ErrNotFound     = New(constant.ErrorCodeNotFound, http.StatusNotFound, "error.notFound")
+	ErrService      = New(constant.ErrorCodeService, http.StatusInternalServerError, "error.service")
+	ErrDuplicate    = New(constant.ErrorCodeDuplicate, http.StatusConflict, "error.duplicate")
+	ErrInvalid      = New(constant.ErrorCodeInvalid, http.StatusBadRequest, "error.invalid")
+	ErrReject       = New(constant.ErrorCodeReject, http.StatusForbidden, "error.reject")
+	ErrUnauthorized = New(constant.ErrorCodeReject, http.StatusUnauthorized, "error.unauthorized")
+	ErrConflict     = New(constant.ErrorCodeConflict, http.StatusConflict, "error.conflict")
+)
+
+func New(code, status int, msgKey string, args ...interface{}) *Error {
+	return &Error{
+		Code:   code,
+		Status: status,
+		MsgKey: msgKey,
+		Args:   args,
+	}
+}
+
+// Wrap 包装原始错误，err为nil时返回nil
+func Wrap(err error, code, status int, msgKey string, args ...interface{}) *Error {
+	if err == nil {
+		return nil
+	}
+	e := New(code, status, msgKey, args...)
+	e.Cause = err
+	return e
+}
+
+// As 获取错误链中的 *Error
+func As(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// Is 判断错误链中是否有与target错误码及消息键相同的 *Error
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.Code == t.Code && e.MsgKey == t.MsgKey
+}
+
+func (e *Error) Error() string {
+	msg := e.Message(DefaultLang)
+	if e.Cause != nil {
+		return fmt.Sprintf("[%d] %s: %v", e.Code, msg, e.Cause)
+	}
+	return fmt.Sprintf("[%d] %s", e.Code, msg)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Message 获取指定语言的错误消息
+func (e *Error) Message(lang string) string {
+	return Message(lang, e.MsgKey, e.Args...)
+}
+
+func (e *Error) clone() *Error {
+	c := *e
+	return &c
+}
+
+// WithCause 返回附带原始错误的副本，预定义错误不会被修改
+func (e *Error) WithCause(err error) *Error {
+	c := e.clone()
+	c.Cause = err
+	return c
+}
+
+// WithMsg 返回使用其他消息键的副本
+func (e *Error) WithMsg(msgKey string, args ...interface{}) *Error {
+	c := e.clone()
+	c.MsgKey = msgKey
+	c.Args = args
+	return c
+}
+
+func (e *Error) WithArgs(args ...interface{}) *Error {
+	c := e.clone()
+	c.Args = args
+	return c
+}
+
+func (e *Error) WithData(data interface{}) *Error {
+	c := e.clone()
+	c.Data = data
+	return c
+}
+
+// LocalizedData 获取指定语言的附加信息
+func (e *Error) LocalizedData(lang string) interface{} {
+	if l, ok := e.Data.(Localizer); ok {
+		return l.Localize(lang)
+	}
+	return e.Data
+}
@@ -0,0 +1,86 @@
+package apperr
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	LangZh = "zh"
+	LangEn = "en"
+)
+
+var DefaultLang = LangZh
+
+var (
+	messageLock sync.RWMutex
+	messages    = map[string]map[string]string{
+		LangZh: {
+			"error.unknown":      "未知错误",
+			"error.bodyParse":    "参数解析失败",
+			"error.lackOfField":  "缺少必要参数",
+			"error.notFound":     "数据不存在",
+			"error.service":      "服务异常",
+			"error.duplicate":    "数据已存在",
+			"error.invalid":      "参数不合法",
+			"error.reject":       "无权访问",
+			"error.unauthorized": "未登录或登录已失效",
+			"error.required":     "%s不能为空",
+			"error.conflict":     "数据已被修改，请刷新后重试",
+		},
+		LangEn: {
+			"error.unknown":      "Unknown error",
+			"error.bodyParse":    "Failed to parse request",
+			"error.lackOfField":  "Missing required field",
+			"error.notFound":     "Not found",
+			"error.service":      "Internal service error",
+			"error.duplicate":    "Already exists",
+			"error.invalid":      "Invalid parameter",
+			"error.reject":       "Access denied",
+			"error.unauthorized": "Not logged in or session expired",
+			"error.required":     "%s is required",
+			"error.conflict":     "The record was modified by someone else, please reload and retry",
+		},
+	}
+)
+
+// RegisterMessages 注册或覆盖指定语言的消息，消息内容可包含fmt格式化占位符
+func RegisterMessages(lang string, msgs map[string]string) {
+	messageLock.Lock()
+	defer messageLock.Unlock()
+	m, ok := messages[lang]
+	if !ok {
+		m = make(map[string]string)
+		messages[lang] = m
+	}
+	for k, v := range msgs {
+		m[k] = v
+	}
+}
+
+// Message 获取消息，语言不存在时回退到默认语言，消息键不存在时返回消息键本身
+func Message(lang, key string, args ...interface{}) string {
+	messageLock.RLock()
+	msg, ok := messages[normalizeLang(lang)][key]
+	if !ok {
+		msg, ok = messages[DefaultLang][key]
+	}
+	messageLock.RUnlock()
+	if !ok {
+		msg = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// normalizeLang zh-CN / zh_TW 等统一为zh
+func normalizeLang(lang string) string {
+	lang = strings.ToLower(lang)
+	if i := strings.IndexAny(lang, "-_"); i > 0 {
+		lang = lang[:i]
+	}
+	return lang
+}
@@ -0,0 +1,52 @@
+package authorization
+
+// Explanation 一次权限校验的结果及依据
+type Explanation struct {
+	Subject  string `json:"subject"`
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+	TenantId string `json:"tenantId"`
+	Allowed  bool   `json:"allowed"`
+	// Policy 按规则匹配时起决定作用的规则，未匹配任何规则时为空
+	Policy []string `json:"policy"`
+	// Roles 主体在该租户下直接及间接拥有的角色
+	Roles []string `json:"roles"`
+	// SuperAdmin 按规则匹配未通过，因超级管理员而放行
+	SuperAdmin bool `json:"superAdmin"`
+}
+
+// Explain 与 CheckSubjectPermissions 的校验一致，同时返回匹配的规则及主体的角色，用于排查权限问题
+func (s *authorizationService) Explain(subject, resource, action, tenantId string) (*Explanation, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	allowed, err := s.enforcer.Enforce(subject, resource, action, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	matched, policy, err := s.enforcer.EnforceExWithMatcher(policyMatcher, subject, resource, action, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := s.enforcer.GetImplicitRolesForUser(subject, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	return &Explanation{
+		Subject:    subject,
+		Resource:   resource,
+		Action:     action,
+		TenantId:   tenantId,
+		Allowed:    allowed,
+		Policy:     policy,
+		Roles:      roles,
+		SuperAdmin: allowed && !matched,
+	}, nil
+}
+
+func Explain(subject, resource, action, tenantId string) (*Explanation, error) {
+	s, err := serviceFor(tenantId)
+	if err != nil {
+		return nil, err
+	}
+	return s.Explain(subject, resource, action, tenantId)
+}
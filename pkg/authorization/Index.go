@@ -1,71 +1,315 @@
 package authorization
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
 	"xorm.io/xorm"
 
+	"github.com/yockii/qscore/pkg/cache"
+	"github.com/yockii/qscore/pkg/config"
 	"github.com/yockii/qscore/pkg/constant"
 	"github.com/yockii/qscore/pkg/database"
 	"github.com/yockii/qscore/pkg/logger"
 )
 
+// authorizationService casbin.Enforcer 非并发安全，策略的读写均需持有lock
 type authorizationService struct {
+	lock       sync.RWMutex
 	enforcer   *casbin.Enforcer
+	adapter    *adapter
 	superAdmin string
+	watcher    *Watcher
+	// filter 仅加载了部分租户的策略时不为nil
+	filter *Filter
 }
 
 var defaultService *authorizationService
 
+const (
+	// policyMatcher 按规则匹配，Explain 以此判断是否因超级管理员放行
+	policyMatcher = `g(r.sub, p.sub, r.tenant) && keyMatch2(r.obj, p.obj) && (r.act == p.act || p.act == "*") && r.tenant == p.tenant`
+	// superAdminMatcher 超级管理员不匹配deny规则，避免其他主体更优先的deny规则拒绝超级管理员，见 Permission.go
+	superAdminMatcher = ` || checkSuperAdmin(r.sub) && p.eft != "deny"`
+)
+
 func SetSuperAdmin(admin string) {
 	defaultService.superAdmin = admin
 }
 
+// SuperAdmin 超级管理员角色名，拥有该角色(域为空)的主体跳过权限校验
+func SuperAdmin() string {
+	return defaultService.superAdmin
+}
+
+// Init 使用配置项 authorization.datasource 指定的数据源，未配置时使用默认数据源
 func Init() {
 	defaultService = &authorizationService{
 		superAdmin: constant.DefaultRoleName,
 	}
-	if err := defaultService.Initial(database.DB); err != nil {
-		logger.Panicf("初始化默认权限系统失败，系统不应在无权限安全保护状态下运行", err)
+	db := database.Engine(config.GetString("authorization.datasource"))
+	if db == nil {
+		logger.Panicf("权限系统数据源 %s 不存在", config.GetString("authorization.datasource"))
+	}
+	if err := checkTables(db); err != nil {
+		logger.Panicf("初始化默认权限系统失败: %v", err)
+	}
+	// 启用租户缓存时默认权限服务仅加载全局策略，租户的策略由 TenantEnforcers 按需加载
+	var filter *Filter
+	if config.GetInt("authorization.tenantCache.size") > 0 {
+		filter = &Filter{}
+	}
+	if err := defaultService.initial(db, filter); err != nil {
+		logger.Panicf("初始化默认权限系统失败，系统不应在无权限安全保护状态下运行: %v", err)
+	}
+	// 多实例部署时通过redis通知其他实例策略变更，见 Watcher.go
+	if config.GetString("authorization.watcher.type") == "redis" {
+		if !cache.Enabled() {
+			logger.Panicf("权限策略变更通知需要先初始化redis")
+		}
+		transport := NewRedisTransport(config.GetString("authorization.watcher.channel"))
+		if err := defaultService.EnableWatcher(transport, config.GetDuration("authorization.watcher.debounce")); err != nil {
+			logger.Panicf("启用权限策略变更通知失败: %v", err)
+		}
+	}
+	// 按租户缓存的权限服务，见 Tenant.go
+	if size := config.GetInt("authorization.tenantCache.size"); size > 0 {
+		tenantEnforcers = NewTenantEnforcers(db, size)
+		if config.GetString("authorization.watcher.type") == "redis" {
+			transport := NewRedisTransport(config.GetString("authorization.watcher.channel"))
+			if err := tenantEnforcers.EnableWatcher(transport, config.GetDuration("authorization.watcher.debounce")); err != nil {
+				logger.Panicf("启用租户权限策略变更通知失败: %v", err)
+			}
+		}
+	}
+}
+
+func (s *authorizationService) Initial(db xorm.EngineInterface) error {
+	if err := checkTables(db); err != nil {
+		return err
 	}
+	return s.initial(db, nil)
 }
 
-func (s *authorizationService) Initial(db *xorm.Engine) error {
+// checkTables 权限表由数据库迁移创建，未迁移时给出明确的错误而非casbin加载失败
+func checkTables(db xorm.EngineInterface) error {
+	for _, bean := range []interface{}{new(CasbinPolicy), new(CasbinRelationship)} {
+		exist, err := db.IsTableExist(bean)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			return fmt.Errorf("权限表 %s 不存在，请先执行 database.MigrateUp 或将 database.autoMigrate 配置为true", db.TableName(bean, true))
+		}
+	}
+	return nil
+}
+
+// initial filter不为nil时仅加载指定租户及全局的策略
+func (s *authorizationService) initial(db xorm.EngineInterface, filter *Filter) error {
 	a, err := NewAdapter(db)
 	if err != nil {
 		return err
 	}
+	// 标记为已过滤，避免创建enforcer时加载全部策略
+	a.filtered = filter != nil
 	m := model.NewModel()
 	m.AddDef("r", "r", "sub, obj, act, tenant")
 	m.AddDef("p", "p", "sub, obj, act, eft, priority, tenant, resId")
 	m.AddDef("g", "g", "_, _, _")
 	m.AddDef("e", "e", "priority(p.eft) || deny")
-	m.AddDef("m", "m", "g(r.sub, p.sub, r.tenant) && keyMatch2(r.obj, p.obj) && r.act == p.act && r.tenant == p.tenant || checkSuperAdmin(r.sub)")
+	m.AddDef("m", "m", policyMatcher+superAdminMatcher)
 
 	s.enforcer, err = casbin.NewEnforcer(m, a)
 	if err != nil {
 		return err
 	}
+	s.adapter = a
 
 	s.enforcer.AddFunction("checkSuperAdmin", func(arguments ...interface{}) (interface{}, error) {
 		un := arguments[0].(string)
 		return s.enforcer.HasRoleForUser(un, s.superAdmin)
 	})
+	if filter != nil {
+		s.filter = filter
+		return s.enforcer.LoadFilteredPolicy(filter)
+	}
 	return nil
 }
+
+// loadPolicy 全量加载策略，仅加载了部分租户时重新加载这些租户的策略，调用方需持有lock
+func (s *authorizationService) loadPolicy() error {
+	if s.filter != nil {
+		return s.enforcer.LoadFilteredPolicy(s.filter)
+	}
+	return s.enforcer.LoadPolicy()
+}
+
+// EnableWatcher 启用多实例间的策略变更通知，已启用时关闭原有的通知
+func (s *authorizationService) EnableWatcher(transport WatcherTransport, debounce time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	w := NewWatcher(transport, debounce)
+	if err := s.enforcer.SetWatcher(w); err != nil {
+		return err
+	}
+	if err := w.start(s.handlePolicyChange); err != nil {
+		return err
+	}
+	if s.watcher != nil {
+		s.watcher.Close()
+	}
+	s.watcher = w
+	return nil
+}
+
+// SubjectResource 批量授权时的单个资源
+type SubjectResource struct {
+	Resource   string
+	Action     string
+	ResourceId string
+}
+
+func (r SubjectResource) rule(subject, tenantId string) []string {
+	return []string{subject, r.Resource, r.Action, EffectAllow, priorityDefault, tenantId, r.ResourceId}
+}
+
+// CloseWatcher 关闭策略变更通知
+func (s *authorizationService) CloseWatcher() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.watcher != nil {
+		s.watcher.Close()
+		s.watcher = nil
+	}
+}
+
+// IsSuperAdmin 主体是否拥有超级管理员角色
+func (s *authorizationService) IsSuperAdmin(subject string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.enforcer.HasRoleForUser(subject, s.superAdmin)
+}
+
+// IsTenantMember 主体在该租户下拥有角色，或为超级管理员
+func (s *authorizationService) IsTenantMember(subject, tenantId string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	isSuperAdmin, err := s.enforcer.HasRoleForUser(subject, s.superAdmin)
+	if err != nil || isSuperAdmin {
+		return isSuperAdmin, err
+	}
+	roles, err := s.enforcer.GetRolesForUser(subject, tenantId)
+	if err != nil {
+		return false, err
+	}
+	return len(roles) > 0, nil
+}
 func (s *authorizationService) AddSubjectResource(subject string, resourceTarget, action, tenantId, resourceId string) (bool, error) {
-	return s.enforcer.AddPermissionForUser(subject, resourceTarget, action, "allow", "10", tenantId, resourceId)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.enforcer.AddPermissionForUser(subject, resourceTarget, action, EffectAllow, priorityDefault, tenantId, resourceId)
 }
 func (s *authorizationService) RemoveSubjectResource(subject string, resourceTarget, action, tenantId, resourceId string) (bool, error) {
-	return s.enforcer.DeletePermissionForUser(subject, resourceTarget, action, "allow", "10", tenantId, resourceId)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.enforcer.DeletePermissionForUser(subject, resourceTarget, action, EffectAllow, priorityDefault, tenantId, resourceId)
+}
+
+// AddSubjectResourceBatch 批量授权，已存在的忽略，在同一事务中写入
+func (s *authorizationService) AddSubjectResourceBatch(subject, tenantId string, resources []SubjectResource) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var rules [][]string
+	for _, r := range resources {
+		rule := r.rule(subject, tenantId)
+		// casbin 在任意一条规则已存在时整批忽略，需先排除
+		if !s.enforcer.HasPolicy(rule) && !containsRule(rules, rule) {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return false, nil
+	}
+	return s.enforcer.AddPolicies(rules)
+}
+
+// RemoveSubjectResourceBatch 批量取消授权，不存在的忽略，在同一事务中删除
+func (s *authorizationService) RemoveSubjectResourceBatch(subject, tenantId string, resources []SubjectResource) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var rules [][]string
+	for _, r := range resources {
+		rule := r.rule(subject, tenantId)
+		if s.enforcer.HasPolicy(rule) && !containsRule(rules, rule) {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return false, nil
+	}
+	return s.enforcer.RemovePolicies(rules)
+}
+
+// SetSubjectResources 以resources替换主体在该租户下默认优先级的全部授权，deny及其他优先级的规则不受影响，在同一事务中完成
+func (s *authorizationService) SetSubjectResources(subject, tenantId string, resources []SubjectResource) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	current := s.enforcer.GetFilteredPolicy(0, subject, "", "", EffectAllow, priorityDefault, tenantId)
+	var target, removed, added [][]string
+	for _, r := range resources {
+		if rule := r.rule(subject, tenantId); !containsRule(target, rule) {
+			target = append(target, rule)
+		}
+	}
+	for _, rule := range current {
+		if !containsRule(target, rule) {
+			removed = append(removed, rule)
+		}
+	}
+	for _, rule := range target {
+		if !containsRule(current, rule) {
+			added = append(added, rule)
+		}
+	}
+	if len(removed) == 0 && len(added) == 0 {
+		return false, nil
+	}
+	if err := s.adapter.UpdatePolicies("p", "p", removed, added); err != nil {
+		return false, err
+	}
+	// 已持久化，仅更新内存中的策略并通知其他实例
+	s.enforcer.EnableAutoSave(false)
+	defer s.enforcer.EnableAutoSave(true)
+	if len(removed) > 0 {
+		if _, err := s.enforcer.RemovePolicies(removed); err != nil {
+			return false, err
+		}
+	}
+	if len(added) > 0 {
+		if _, err := s.enforcer.AddPolicies(added); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
 }
 func (s *authorizationService) AddSubjectGroup(subject, group, tenantId string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	return s.enforcer.AddRoleForUser(subject, group, tenantId)
 }
 func (s *authorizationService) RemoveSubjectGroup(subject, group, tenantId string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	return s.enforcer.DeleteRoleForUser(subject, group, tenantId)
 }
 func (s *authorizationService) GetSubjectResourceIds(subject string, tenantId string) (isSuperAdmin bool, ids []string, err error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	isSuperAdmin, err = s.enforcer.HasRoleForUser(subject, s.superAdmin)
 	if err != nil {
 		return
@@ -78,12 +322,23 @@ func (s *authorizationService) GetSubjectResourceIds(subject string, tenantId st
 	if err != nil {
 		return
 	}
+	// deny规则禁止的资源不返回
+	denied := make(map[string]bool)
 	for _, r := range resources {
-		ids = append(ids, r[6])
+		if r[3] == EffectDeny {
+			denied[r[6]] = true
+		}
+	}
+	for _, r := range resources {
+		if r[3] != EffectDeny && r[6] != "" && !denied[r[6]] {
+			ids = append(ids, r[6])
+		}
 	}
 	return
 }
 func (s *authorizationService) GetSubjectGroupIds(subject, tenantId string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	roleIds, err := s.enforcer.GetRolesForUser(subject, tenantId)
 	if err != nil {
 		return nil, err
@@ -92,46 +347,152 @@ func (s *authorizationService) GetSubjectGroupIds(subject, tenantId string) ([]s
 	return roleIds, nil
 }
 func (s *authorizationService) CheckSubjectPermissions(subject, resource, action, tenantId string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	ok, _ := s.enforcer.Enforce(subject, resource, action, tenantId)
 	return ok
 }
 func (s *authorizationService) RemoveSubjectGroups(subject, tenantId string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	return s.enforcer.DeleteRolesForUserInDomain(subject, tenantId)
 }
 func (s *authorizationService) RemoveSubjectResources(subject string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	return s.enforcer.DeletePermissionsForUser(subject)
 }
 func (s *authorizationService) GetSubjectResources(subject, tenantId string) [][]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	return s.enforcer.GetPermissionsForUserInDomain(subject, tenantId)
 }
 
+func EnableWatcher(transport WatcherTransport, debounce time.Duration) error {
+	return defaultService.EnableWatcher(transport, debounce)
+}
+func CloseWatcher() {
+	defaultService.CloseWatcher()
+}
+func IsSuperAdmin(subject string) (bool, error) {
+	return defaultService.IsSuperAdmin(subject)
+}
+func IsTenantMember(subject, tenantId string) (bool, error) {
+	s, err := serviceFor(tenantId)
+	if err != nil {
+		return false, err
+	}
+	return s.IsTenantMember(subject, tenantId)
+}
 func AddSubjectResource(subject string, resourceTarget, action, tenantId, resourceId string) (bool, error) {
-	return defaultService.AddSubjectResource(subject, resourceTarget, action, tenantId, resourceId)
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.AddSubjectResource(subject, resourceTarget, action, tenantId, resourceId)
+	})
 }
 func RemoveSubjectResource(subject string, resourceTarget, action, tenantId, resourceId string) (bool, error) {
-	return defaultService.RemoveSubjectResource(subject, resourceTarget, action, tenantId, resourceId)
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.RemoveSubjectResource(subject, resourceTarget, action, tenantId, resourceId)
+	})
+}
+func AddSubjectResourceBatch(subject, tenantId string, resources []SubjectResource) (bool, error) {
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.AddSubjectResourceBatch(subject, tenantId, resources)
+	})
+}
+func RemoveSubjectResourceBatch(subject, tenantId string, resources []SubjectResource) (bool, error) {
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.RemoveSubjectResourceBatch(subject, tenantId, resources)
+	})
+}
+func SetSubjectResources(subject, tenantId string, resources []SubjectResource) (bool, error) {
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.SetSubjectResources(subject, tenantId, resources)
+	})
 }
 func AddSubjectGroup(subject, group, tenantId string) (bool, error) {
-	return defaultService.AddSubjectGroup(subject, group, tenantId)
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.AddSubjectGroup(subject, group, tenantId)
+	})
 }
 func RemoveSubjectGroup(subject, group, tenantId string) (bool, error) {
-	return defaultService.RemoveSubjectGroup(subject, group, tenantId)
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.RemoveSubjectGroup(subject, group, tenantId)
+	})
 }
 func GetSubjectResourceIds(subject string, tenantId string) (isSuperAdmin bool, ids []string, err error) {
-	return defaultService.GetSubjectResourceIds(subject, tenantId)
+	s, err := serviceFor(tenantId)
+	if err != nil {
+		return false, nil, err
+	}
+	return s.GetSubjectResourceIds(subject, tenantId)
 }
 func GetSubjectGroupIds(subject, tenantId string) ([]string, error) {
-	return defaultService.GetSubjectGroupIds(subject, tenantId)
+	s, err := serviceFor(tenantId)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSubjectGroupIds(subject, tenantId)
 }
+
+// CheckSubjectPermissions 租户的权限服务加载失败时拒绝
 func CheckSubjectPermissions(subject, resource, action, tenantId string) bool {
-	return defaultService.CheckSubjectPermissions(subject, resource, action, tenantId)
+	s, err := serviceFor(tenantId)
+	if err != nil {
+		logger.Errorf("加载租户 %s 的权限策略失败: %v", tenantId, err)
+		return false
+	}
+	return s.CheckSubjectPermissions(subject, resource, action, tenantId)
 }
 func RemoveSubjectGroups(subject, tenantId string) (bool, error) {
-	return defaultService.RemoveSubjectGroups(subject, tenantId)
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.RemoveSubjectGroups(subject, tenantId)
+	})
 }
+
+// RemoveSubjectResources 删除主体在全部租户下的授权，启用租户缓存时未加载的租户同样从数据库中删除
 func RemoveSubjectResources(subject string) (bool, error) {
-	return defaultService.RemoveSubjectResources(subject)
+	removed, err := defaultService.RemoveSubjectResources(subject)
+	if err == nil && tenantEnforcers != nil {
+		// 默认权限服务只加载了全局策略，仅有租户授权时removed为false，缓存的租户需重新加载
+		tenantEnforcers.invalidateAll(true)
+	}
+	return removed, err
 }
 func GetSubjectResources(subject, tenantId string) [][]string {
-	return defaultService.GetSubjectResources(subject, tenantId)
+	s, err := serviceFor(tenantId)
+	if err != nil {
+		logger.Errorf("加载租户 %s 的权限策略失败: %v", tenantId, err)
+		return nil
+	}
+	return s.GetSubjectResources(subject, tenantId)
+}
+
+// serviceFor 启用租户缓存时，租户的读写使用缓存中该租户的权限服务，全局(租户为空)使用默认权限服务
+func serviceFor(tenantId string) (*authorizationService, error) {
+	if tenantEnforcers == nil || tenantId == "" {
+		return defaultService, nil
+	}
+	return tenantEnforcers.get(tenantId)
+}
+
+// write 通过 serviceFor 修改策略，全局策略变更后清空租户缓存(缓存中的enforcer均加载了全局策略)
+func write(tenantId string, fn func(s *authorizationService) (bool, error)) (bool, error) {
+	s, err := serviceFor(tenantId)
+	if err != nil {
+		return false, err
+	}
+	changed, err := fn(s)
+	if changed && tenantId == "" && tenantEnforcers != nil {
+		tenantEnforcers.invalidateAll(false)
+	}
+	return changed, err
+}
+
+func containsRule(rules [][]string, rule []string) bool {
+	for _, r := range rules {
+		if strings.Join(r, model.DefaultSep) == strings.Join(rule, model.DefaultSep) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,95 @@
+package authorization
+
+import (
+	"errors"
+	"strconv"
+)
+
+// 规则的效果及优先级，priority越小越优先，一次校验匹配多条规则时以最优先的一条为准：
+//
+//	// 用户单独禁止某接口，优先于其角色的授权
+//	authorization.AddSubjectDeny(userId, "/api/v1/user/delete", "POST", tenantId, resourceId)
+//
+//	// 除用户管理外的全部接口，无需授予超级管理员角色
+//	authorization.AddSubjectPermission(roleId, authorization.ResourceAll, authorization.ActionAll,
+//		authorization.EffectAllow, authorization.PriorityFallback, tenantId, "")
+//	authorization.AddSubjectDeny(roleId, "/api/v1/user/*", authorization.ActionAll, tenantId, "")
+//
+// 优先级相同的allow与deny先后不确定，需使用不同的优先级。
+// 超级管理员不受其他主体的deny影响，但仍受匹配到其自身的deny约束
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+
+	// ResourceAll 匹配全部资源(keyMatch2)
+	ResourceAll = "/*"
+	// ActionAll 匹配全部行为
+	ActionAll = "*"
+
+	// PriorityDeny AddSubjectDeny 使用的优先级，高于默认授权
+	PriorityDeny = 5
+	// PriorityDefault AddSubjectResource 等使用的优先级
+	PriorityDefault = 10
+	// PriorityFallback 用于 ResourceAll 等宽泛的授权，低于默认授权及deny
+	PriorityFallback = 100
+)
+
+var priorityDefault = strconv.Itoa(PriorityDefault)
+
+func permissionRule(subject, resourceTarget, action, effect string, priority int, tenantId, resourceId string) ([]string, error) {
+	if effect != EffectAllow && effect != EffectDeny {
+		return nil, errors.New("策略效果只能是 allow 或 deny: " + effect)
+	}
+	return []string{subject, resourceTarget, action, effect, strconv.Itoa(priority), tenantId, resourceId}, nil
+}
+
+// AddSubjectPermission 添加指定效果及优先级的规则
+func (s *authorizationService) AddSubjectPermission(subject, resourceTarget, action, effect string, priority int, tenantId, resourceId string) (bool, error) {
+	rule, err := permissionRule(subject, resourceTarget, action, effect, priority, tenantId, resourceId)
+	if err != nil {
+		return false, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.enforcer.AddPolicy(rule)
+}
+
+// RemoveSubjectPermission 删除 AddSubjectPermission 添加的规则，参数需与添加时一致
+func (s *authorizationService) RemoveSubjectPermission(subject, resourceTarget, action, effect string, priority int, tenantId, resourceId string) (bool, error) {
+	rule, err := permissionRule(subject, resourceTarget, action, effect, priority, tenantId, resourceId)
+	if err != nil {
+		return false, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.enforcer.RemovePolicy(rule)
+}
+
+// AddSubjectDeny 以 PriorityDeny 禁止主体访问资源，对继承该主体的用户同样生效
+func (s *authorizationService) AddSubjectDeny(subject, resourceTarget, action, tenantId, resourceId string) (bool, error) {
+	return s.AddSubjectPermission(subject, resourceTarget, action, EffectDeny, PriorityDeny, tenantId, resourceId)
+}
+func (s *authorizationService) RemoveSubjectDeny(subject, resourceTarget, action, tenantId, resourceId string) (bool, error) {
+	return s.RemoveSubjectPermission(subject, resourceTarget, action, EffectDeny, PriorityDeny, tenantId, resourceId)
+}
+
+func AddSubjectPermission(subject, resourceTarget, action, effect string, priority int, tenantId, resourceId string) (bool, error) {
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.AddSubjectPermission(subject, resourceTarget, action, effect, priority, tenantId, resourceId)
+	})
+}
+func RemoveSubjectPermission(subject, resourceTarget, action, effect string, priority int, tenantId, resourceId string) (bool, error) {
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.RemoveSubjectPermission(subject, resourceTarget, action, effect, priority, tenantId, resourceId)
+	})
+}
+func AddSubjectDeny(subject, resourceTarget, action, tenantId, resourceId string) (bool, error) {
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.AddSubjectDeny(subject, resourceTarget, action, tenantId, resourceId)
+	})
+}
+func RemoveSubjectDeny(subject, resourceTarget, action, tenantId, resourceId string) (bool, error) {
+	return write(tenantId, func(s *authorizationService) (bool, error) {
+		return s.RemoveSubjectDeny(subject, resourceTarget, action, tenantId, resourceId)
+	})
+}
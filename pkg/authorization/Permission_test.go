@@ -0,0 +1,140 @@
+package authorization
+
+import (
+	"testing"
+
+	_ "modernc.org/sqlite"
+	"xorm.io/xorm"
+
+	"github.com/yockii/qscore/pkg/constant"
+	"github.com/yockii/qscore/pkg/database"
+)
+
+const testTenant = "tenant1"
+
+// newTestService 每个测试使用独立的sqlite内存库
+func newTestService(t *testing.T) *authorizationService {
+	engine, err := xorm.NewEngine("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.DB().SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = engine.Close() })
+	if err = database.NewMigrator(engine).Up(); err != nil {
+		t.Fatal(err)
+	}
+	s := &authorizationService{superAdmin: constant.DefaultRoleName}
+	if err = s.Initial(engine); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// mustChange 返回校验策略修改结果的函数，修改失败或未变更时终止测试
+func mustChange(t *testing.T) func(changed bool, err error) {
+	return func(changed bool, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !changed {
+			t.Fatal("策略未变更")
+		}
+	}
+}
+
+func TestUserDenyOverridesRoleAllow(t *testing.T) {
+	s := newTestService(t)
+	changed := mustChange(t)
+	changed(s.AddSubjectGroup("user1", "role1", testTenant))
+	changed(s.AddSubjectResource("role1", "/api/v1/user/delete", "POST", testTenant, ""))
+	if !s.CheckSubjectPermissions("user1", "/api/v1/user/delete", "POST", testTenant) {
+		t.Fatal("角色授权未生效")
+	}
+
+	changed(s.AddSubjectDeny("user1", "/api/v1/user/delete", "POST", testTenant, ""))
+	if s.CheckSubjectPermissions("user1", "/api/v1/user/delete", "POST", testTenant) {
+		t.Fatal("用户的deny应优先于角色的allow")
+	}
+	// 同一角色的其他用户不受影响
+	changed(s.AddSubjectGroup("user2", "role1", testTenant))
+	if !s.CheckSubjectPermissions("user2", "/api/v1/user/delete", "POST", testTenant) {
+		t.Fatal("deny不应影响其他用户")
+	}
+}
+
+func TestRoleDenyOverridesUserAllow(t *testing.T) {
+	s := newTestService(t)
+	changed := mustChange(t)
+	changed(s.AddSubjectGroup("user1", "role1", testTenant))
+	// 默认优先级的用户授权
+	changed(s.AddSubjectResource("user1", "/api/v1/report", "GET", testTenant, ""))
+	if !s.CheckSubjectPermissions("user1", "/api/v1/report", "GET", testTenant) {
+		t.Fatal("用户授权未生效")
+	}
+
+	changed(s.AddSubjectDeny("role1", "/api/v1/report", "GET", testTenant, ""))
+	if s.CheckSubjectPermissions("user1", "/api/v1/report", "GET", testTenant) {
+		t.Fatal("角色的deny应优先于默认优先级的用户授权")
+	}
+	// 与添加的先后顺序无关
+	changed(s.RemoveSubjectResource("user1", "/api/v1/report", "GET", testTenant, ""))
+	changed(s.AddSubjectResource("user1", "/api/v1/report", "GET", testTenant, ""))
+	if s.CheckSubjectPermissions("user1", "/api/v1/report", "GET", testTenant) {
+		t.Fatal("deny之后添加的授权不应覆盖deny")
+	}
+
+	changed(s.RemoveSubjectDeny("role1", "/api/v1/report", "GET", testTenant, ""))
+	if !s.CheckSubjectPermissions("user1", "/api/v1/report", "GET", testTenant) {
+		t.Fatal("删除deny后应恢复授权")
+	}
+}
+
+func TestActionAll(t *testing.T) {
+	s := newTestService(t)
+	changed := mustChange(t)
+	changed(s.AddSubjectGroup("user1", "role1", testTenant))
+	changed(s.AddSubjectPermission("role1", "/api/v1/dict/*", ActionAll, EffectAllow, PriorityDefault, testTenant, ""))
+
+	for _, action := range []string{"GET", "POST", "PUT", "DELETE"} {
+		if !s.CheckSubjectPermissions("user1", "/api/v1/dict/list", action, testTenant) {
+			t.Fatalf("ActionAll 应匹配 %s", action)
+		}
+	}
+	if s.CheckSubjectPermissions("user1", "/api/v1/user/list", "GET", testTenant) {
+		t.Fatal("ActionAll 不应放宽资源匹配")
+	}
+	if s.CheckSubjectPermissions("user1", "/api/v1/dict/list", "GET", "tenant2") {
+		t.Fatal("授权不应跨租户生效")
+	}
+
+	// 请求的行为为*时不匹配具体行为的授权
+	changed(s.AddSubjectResource("role1", "/api/v1/role/list", "GET", testTenant, ""))
+	if s.CheckSubjectPermissions("user1", "/api/v1/role/list", ActionAll, testTenant) {
+		t.Fatal("请求的行为为*时不应匹配GET授权")
+	}
+}
+
+func TestSuperAdminIgnoresOtherSubjectsDeny(t *testing.T) {
+	s := newTestService(t)
+	changed := mustChange(t)
+	changed(s.AddSubjectGroup("admin", s.superAdmin, ""))
+	changed(s.AddSubjectGroup("user1", "role1", testTenant))
+	changed(s.AddSubjectResource("role1", "/api/v1/user/list", "GET", testTenant, ""))
+	// 其他主体更优先的deny
+	changed(s.AddSubjectPermission("role1", "/api/v1/user/*", ActionAll, EffectDeny, 1, testTenant, ""))
+	changed(s.AddSubjectPermission("user1", ResourceAll, ActionAll, EffectDeny, 1, testTenant, ""))
+
+	if s.CheckSubjectPermissions("user1", "/api/v1/user/list", "GET", testTenant) {
+		t.Fatal("user1应被deny拒绝")
+	}
+	if !s.CheckSubjectPermissions("admin", "/api/v1/user/list", "GET", testTenant) {
+		t.Fatal("超级管理员不应被其他主体的deny拒绝")
+	}
+
+	// 匹配到超级管理员自身的deny仍然生效
+	changed(s.AddSubjectDeny("admin", "/api/v1/user/list", "GET", testTenant, ""))
+	if s.CheckSubjectPermissions("admin", "/api/v1/user/list", "GET", testTenant) {
+		t.Fatal("超级管理员应受自身的deny约束")
+	}
+}
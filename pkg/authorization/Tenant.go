@@ -0,0 +1,281 @@
+package authorization
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"xorm.io/xorm"
+
+	"github.com/yockii/qscore/pkg/constant"
+	"github.com/yockii/qscore/pkg/logger"
+)
+
+const (
+	// policyTenantIndex/groupTenantIndex 租户在p及g规则中的位置
+	policyTenantIndex = 5
+	groupTenantIndex  = 2
+
+	defaultTenantCacheSize = 100
+)
+
+// TenantEnforcers 按租户懒加载的权限服务缓存，每个租户的enforcer仅加载该租户及全局(租户为空)的策略，
+// 超过容量时淘汰最久未使用的租户，适用于租户数量多、单个进程只服务部分租户的场景：
+//
+//	authorization:
+//	  tenantCache:
+//	    size: 100   # 大于0时 Init 创建，通过 authorization.Tenants() 获取
+//
+// 启用后包级函数(CheckSubjectPermissions、AddSubjectResource等)按租户自动使用缓存中的权限服务，
+// 默认权限服务仅加载全局策略；其他实例的变更依赖 Watcher 同步，未启用 Watcher 时需自行调用 Invalidate
+type TenantEnforcers struct {
+	db       xorm.EngineInterface
+	capacity int
+	watcher  *Watcher
+
+	lock  sync.Mutex
+	items map[string]*list.Element
+	// order 最近使用的在前
+	order *list.List
+}
+
+type tenantEntry struct {
+	tenantId string
+	service  *authorizationService
+	// ready 加载完成后关闭，加载失败时err不为空
+	ready chan struct{}
+	err   error
+}
+
+var tenantEnforcers *TenantEnforcers
+
+// Tenants 获取 Init 按配置创建的租户权限服务缓存，未配置时为nil
+func Tenants() *TenantEnforcers {
+	return tenantEnforcers
+}
+
+// NewTenantEnforcers capacity不大于0时使用默认的100
+func NewTenantEnforcers(db xorm.EngineInterface, capacity int) *TenantEnforcers {
+	if capacity <= 0 {
+		capacity = defaultTenantCacheSize
+	}
+	return &TenantEnforcers{
+		db:       db,
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// EnableWatcher 接收策略变更通知并同步到已缓存的租户，缓存中enforcer的变更同样通过该Watcher通知其他实例
+func (c *TenantEnforcers) EnableWatcher(transport WatcherTransport, debounce time.Duration) error {
+	w := NewWatcher(transport, debounce)
+	if err := w.start(c.handlePolicyChange); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	old := c.watcher
+	c.watcher = w
+	c.lock.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Close 关闭策略变更通知并清空缓存
+func (c *TenantEnforcers) Close() {
+	c.lock.Lock()
+	w := c.watcher
+	c.watcher = nil
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	c.lock.Unlock()
+	if w != nil {
+		w.Close()
+	}
+}
+
+// get 获取租户的权限服务，不存在时加载，同一租户并发获取时只加载一次
+func (c *TenantEnforcers) get(tenantId string) (*authorizationService, error) {
+	c.lock.Lock()
+	if el, ok := c.items[tenantId]; ok {
+		c.order.MoveToFront(el)
+		entry := el.Value.(*tenantEntry)
+		c.lock.Unlock()
+		<-entry.ready
+		return entry.service, entry.err
+	}
+	entry := &tenantEntry{tenantId: tenantId, ready: make(chan struct{})}
+	el := c.order.PushFront(entry)
+	c.items[tenantId] = el
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+	w := c.watcher
+	c.lock.Unlock()
+
+	entry.service, entry.err = c.load(tenantId, w)
+	close(entry.ready)
+	if entry.err != nil {
+		c.lock.Lock()
+		if c.items[tenantId] == el {
+			c.removeElement(el)
+		}
+		c.lock.Unlock()
+	}
+	return entry.service, entry.err
+}
+
+func (c *TenantEnforcers) load(tenantId string, w *Watcher) (*authorizationService, error) {
+	s := &authorizationService{superAdmin: constant.DefaultRoleName}
+	if defaultService != nil {
+		s.superAdmin = defaultService.superAdmin
+	}
+	if err := s.initial(c.db, &Filter{TenantIds: []string{tenantId}}); err != nil {
+		return nil, err
+	}
+	if w != nil {
+		// 仅用于通知其他实例，接收由 handlePolicyChange 统一分发
+		if err := s.enforcer.SetWatcher(w); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (c *TenantEnforcers) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*tenantEntry).tenantId)
+}
+
+// Invalidate 移除租户的缓存，下次访问时重新加载
+func (c *TenantEnforcers) Invalidate(tenantId string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if el, ok := c.items[tenantId]; ok {
+		c.removeElement(el)
+	}
+}
+
+// invalidateAll 清空缓存，notify为true时通知其他实例全量加载
+func (c *TenantEnforcers) invalidateAll(notify bool) {
+	c.lock.Lock()
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	w := c.watcher
+	c.lock.Unlock()
+	if notify && w != nil {
+		if err := w.Update(); err != nil {
+			logger.Warn("通知其他实例重新加载租户权限策略失败", err)
+		}
+	}
+}
+
+// Len 已缓存的租户数量
+func (c *TenantEnforcers) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+// handlePolicyChange 将变更分发到已加载完成的租户
+func (c *TenantEnforcers) handlePolicyChange(msgs []*watcherMessage, reload bool) {
+	c.lock.Lock()
+	services := make([]*authorizationService, 0, c.order.Len())
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*tenantEntry)
+		select {
+		case <-entry.ready:
+			if entry.err == nil {
+				services = append(services, entry.service)
+			}
+		default:
+			// 加载中的租户会读取到最新的策略
+		}
+	}
+	c.lock.Unlock()
+	for _, s := range services {
+		s.handlePolicyChange(msgs, reload)
+	}
+}
+
+func (c *TenantEnforcers) CheckSubjectPermissions(subject, resource, action, tenantId string) (bool, error) {
+	s, err := c.get(tenantId)
+	if err != nil {
+		return false, err
+	}
+	return s.CheckSubjectPermissions(subject, resource, action, tenantId), nil
+}
+func (c *TenantEnforcers) GetSubjectResourceIds(subject, tenantId string) (isSuperAdmin bool, ids []string, err error) {
+	s, err := c.get(tenantId)
+	if err != nil {
+		return false, nil, err
+	}
+	return s.GetSubjectResourceIds(subject, tenantId)
+}
+func (c *TenantEnforcers) GetSubjectGroupIds(subject, tenantId string) ([]string, error) {
+	s, err := c.get(tenantId)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSubjectGroupIds(subject, tenantId)
+}
+func (c *TenantEnforcers) GetSubjectResources(subject, tenantId string) ([][]string, error) {
+	s, err := c.get(tenantId)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSubjectResources(subject, tenantId), nil
+}
+func (c *TenantEnforcers) Explain(subject, resource, action, tenantId string) (*Explanation, error) {
+	s, err := c.get(tenantId)
+	if err != nil {
+		return nil, err
+	}
+	return s.Explain(subject, resource, action, tenantId)
+}
+
+// filterMessage 仅加载了部分租户时过滤掉其他租户的规则，全部被过滤时返回nil
+func (s *authorizationService) filterMessage(msg *watcherMessage) *watcherMessage {
+	if s.filter == nil {
+		return msg
+	}
+	index := policyTenantIndex
+	if msg.Sec == "g" {
+		index = groupTenantIndex
+	}
+	switch msg.Op {
+	case watcherOpAdd, watcherOpRemove:
+		rules := s.filterRules(msg.Rules, index)
+		if len(rules) == 0 {
+			return nil
+		}
+		filtered := *msg
+		filtered.Rules = rules
+		return &filtered
+	}
+	return msg
+}
+
+func (s *authorizationService) filterRules(rules [][]string, index int) [][]string {
+	var rst [][]string
+	for _, rule := range rules {
+		if len(rule) > index && s.filter.hasTenant(rule[index]) {
+			rst = append(rst, rule)
+		}
+	}
+	return rst
+}
+
+func (f *Filter) hasTenant(tenantId string) bool {
+	if tenantId == "" {
+		return true
+	}
+	for _, t := range f.TenantIds {
+		if t == tenantId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,421 @@
+package authorization
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/yockii/qscore/pkg/cache"
+	"github.com/yockii/qscore/pkg/logger"
+	"github.com/yockii/qscore/pkg/util"
+)
+
+// 多实例间的策略变更通知，一个实例修改策略后通知其他实例增量更新内存中的策略：
+//
+//	authorization:
+//	  watcher:
+//	    type: redis             # 使用 cache 包的redis发布订阅，需先 cache.InitRedis
+//	    channel: casbin:watcher # 频道名，实际为 cache.Prefix + ":" + channel
+//	    debounce: 200ms         # 合并该时间内收到的变更后统一处理，持续收到变更时最多延迟 watcherMaxWaitFactor 倍该时间
+//
+// 使用MQ时自行创建传输方式，目的地需为广播(如STOMP的/topic/xxx)，否则只有一个实例能收到：
+//
+//	transport := authorization.NewMQTransport(
+//		func(data []byte) error { return mq_stomp.Send("/topic/casbin", data, 0) },
+//		func(handler func([]byte) error) { mq_stomp.RegisterHandlers("/topic/casbin", handler) },
+//	)
+//	err := authorization.EnableWatcher(transport, 0)
+//
+// 以下情况全量重新加载策略：收到SavePolicy等无法增量处理的通知、增量处理失败、
+// 防抖时间内变更过多、传输连接中断后重连(期间可能丢失了消息)
+
+const (
+	watcherOpAdd            = "add"
+	watcherOpRemove         = "remove"
+	watcherOpRemoveFiltered = "removeFiltered"
+	watcherOpReload         = "reload"
+
+	defaultWatcherChannel  = "casbin:watcher"
+	defaultWatcherDebounce = 200 * time.Millisecond
+	// watcherMaxPending 防抖时间内累计超过该数量的变更时直接全量加载
+	watcherMaxPending = 100
+	// watcherMaxWaitFactor 自收到第一条变更起最多等待防抖时间的倍数，避免持续变更时一直不处理
+	watcherMaxWaitFactor = 10
+)
+
+var errWatcherReload = errors.New("需要全量加载策略")
+
+// WatcherTransport 策略变更通知的传输方式
+type WatcherTransport interface {
+	// Publish 广播变更消息，发送者自身也可能收到
+	Publish(data []byte) error
+	// Subscribe 开始接收消息，连接中断并重新订阅后以nil调用handler
+	Subscribe(handler func(data []byte)) error
+	Close() error
+}
+
+type watcherMessage struct {
+	Instance   string     `json:"instance"`
+	Op         string     `json:"op"`
+	Sec        string     `json:"sec,omitempty"`
+	Ptype      string     `json:"ptype,omitempty"`
+	FieldIndex int        `json:"fieldIndex,omitempty"`
+	Rules      [][]string `json:"rules,omitempty"`
+}
+
+// Watcher 实现casbin的 persist.WatcherEx 及 persist.WatcherUpdatable
+// 单独与 casbin.Enforcer 使用时，收到任何变更均通过 SetUpdateCallback 设置的回调全量加载
+type Watcher struct {
+	transport WatcherTransport
+	// handler 由 authorizationService 等设置，处理防抖后的变更，reload为true时需全量加载
+	handler  func(msgs []*watcherMessage, reload bool)
+	instance string
+	debounce time.Duration
+	maxWait  time.Duration
+
+	lock     sync.Mutex
+	callback func(string)
+	pending  []*watcherMessage
+	reload   bool
+	timer    *time.Timer
+	// deadline 本批变更最晚的处理时间，为零值时表示没有待处理的变更
+	deadline time.Time
+	closed   bool
+}
+
+// NewWatcher debounce为0时使用默认的200ms
+func NewWatcher(transport WatcherTransport, debounce time.Duration) *Watcher {
+	if debounce <= 0 {
+		debounce = defaultWatcherDebounce
+	}
+	return &Watcher{
+		transport: transport,
+		instance:  util.GenerateRequestID(),
+		debounce:  debounce,
+		maxWait:   debounce * watcherMaxWaitFactor,
+	}
+}
+
+// SetUpdateCallback 全量加载时调用的回调，enforcer.SetWatcher 时设置为 LoadPolicy
+func (w *Watcher) SetUpdateCallback(callback func(string)) error {
+	w.lock.Lock()
+	w.callback = callback
+	w.lock.Unlock()
+	return nil
+}
+
+// start 开始接收其他实例的通知，handler为nil时使用回调全量加载
+func (w *Watcher) start(handler func(msgs []*watcherMessage, reload bool)) error {
+	w.handler = handler
+	return w.transport.Subscribe(w.receive)
+}
+
+func (w *Watcher) Update() error {
+	return w.publish(&watcherMessage{Op: watcherOpReload})
+}
+
+func (w *Watcher) UpdateForAddPolicy(sec, ptype string, params ...string) error {
+	return w.publish(&watcherMessage{Op: watcherOpAdd, Sec: sec, Ptype: ptype, Rules: [][]string{params}})
+}
+
+func (w *Watcher) UpdateForRemovePolicy(sec, ptype string, params ...string) error {
+	return w.publish(&watcherMessage{Op: watcherOpRemove, Sec: sec, Ptype: ptype, Rules: [][]string{params}})
+}
+
+func (w *Watcher) UpdateForRemoveFilteredPolicy(sec, ptype string, fieldIndex int, fieldValues ...string) error {
+	return w.publish(&watcherMessage{Op: watcherOpRemoveFiltered, Sec: sec, Ptype: ptype, FieldIndex: fieldIndex, Rules: [][]string{fieldValues}})
+}
+
+func (w *Watcher) UpdateForSavePolicy(model.Model) error {
+	return w.Update()
+}
+
+func (w *Watcher) UpdateForAddPolicies(sec string, ptype string, rules ...[]string) error {
+	return w.publish(&watcherMessage{Op: watcherOpAdd, Sec: sec, Ptype: ptype, Rules: rules})
+}
+
+func (w *Watcher) UpdateForRemovePolicies(sec string, ptype string, rules ...[]string) error {
+	return w.publish(&watcherMessage{Op: watcherOpRemove, Sec: sec, Ptype: ptype, Rules: rules})
+}
+
+// UpdateForUpdatePolicy casbin在此处不提供sec及ptype，无法区分p与g，通知全量加载
+func (w *Watcher) UpdateForUpdatePolicy(oldRule, newRule []string) error {
+	return w.Update()
+}
+
+func (w *Watcher) UpdateForUpdatePolicies(oldRules, newRules [][]string) error {
+	return w.Update()
+}
+
+func (w *Watcher) Close() {
+	w.lock.Lock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.lock.Unlock()
+	if err := w.transport.Close(); err != nil {
+		logger.Warn("关闭策略变更通知失败", err)
+	}
+}
+
+func (w *Watcher) publish(msg *watcherMessage) error {
+	msg.Instance = w.instance
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return w.transport.Publish(data)
+}
+
+// receive data为nil表示传输重连，需全量加载
+func (w *Watcher) receive(data []byte) {
+	msg := &watcherMessage{Op: watcherOpReload}
+	if data != nil {
+		if err := json.Unmarshal(data, msg); err != nil {
+			logger.Warn("无法解析的策略变更通知", err)
+			msg = &watcherMessage{Op: watcherOpReload}
+		}
+		if msg.Instance == w.instance {
+			return
+		}
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return
+	}
+	if msg.Op == watcherOpReload || len(w.pending) >= watcherMaxPending {
+		w.reload = true
+		w.pending = nil
+	} else if !w.reload {
+		w.pending = append(w.pending, msg)
+	}
+	now := time.Now()
+	if w.deadline.IsZero() {
+		w.deadline = now.Add(w.maxWait)
+	}
+	wait := w.debounce
+	if remain := w.deadline.Sub(now); remain < wait {
+		wait = remain
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(wait, w.flush)
+	} else {
+		w.timer.Reset(wait)
+	}
+}
+
+func (w *Watcher) flush() {
+	w.lock.Lock()
+	pending, reload, callback := w.pending, w.reload, w.callback
+	w.pending, w.reload, w.deadline = nil, false, time.Time{}
+	closed := w.closed
+	w.lock.Unlock()
+	if closed {
+		return
+	}
+	if w.handler != nil {
+		w.handler(pending, reload)
+	} else if callback != nil {
+		callback(watcherOpReload)
+	}
+}
+
+// handlePolicyChange 处理其他实例的策略变更，增量更新失败时全量加载
+func (s *authorizationService) handlePolicyChange(msgs []*watcherMessage, reload bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !reload {
+		for _, msg := range msgs {
+			if msg = s.filterMessage(msg); msg == nil {
+				continue
+			}
+			if err := applyPolicyChange(s.enforcer, msg); err != nil {
+				if err != errWatcherReload {
+					logger.Warnf("增量更新策略失败，全量加载: %v", err)
+				}
+				reload = true
+				break
+			}
+		}
+	}
+	if !reload {
+		return
+	}
+	if err := s.loadPolicy(); err != nil {
+		logger.Error("全量加载策略失败", err)
+	}
+}
+
+// applyPolicyChange 将其他实例的变更应用到内存中的策略，不再写入数据库及通知
+// 返回 errWatcherReload 或其他错误时调用方需全量加载
+func applyPolicyChange(e *casbin.Enforcer, msg *watcherMessage) error {
+	m := e.GetModel()
+	if _, ok := m[msg.Sec][msg.Ptype]; !ok {
+		return errWatcherReload
+	}
+	var op model.PolicyOp
+	var affected [][]string
+	switch msg.Op {
+	case watcherOpAdd:
+		op = model.PolicyAdd
+		affected = m.AddPoliciesWithAffected(msg.Sec, msg.Ptype, msg.Rules)
+	case watcherOpRemove:
+		op = model.PolicyRemove
+		affected = m.RemovePoliciesWithEffected(msg.Sec, msg.Ptype, msg.Rules)
+	case watcherOpRemoveFiltered:
+		if len(msg.Rules) != 1 {
+			return errWatcherReload
+		}
+		op = model.PolicyRemove
+		_, affected = m.RemoveFilteredPolicy(msg.Sec, msg.Ptype, msg.FieldIndex, msg.Rules[0]...)
+	default:
+		return errWatcherReload
+	}
+	if msg.Sec == "g" && len(affected) > 0 {
+		return e.BuildIncrementalRoleLinks(op, msg.Ptype, affected)
+	}
+	return nil
+}
+
+type redisTransport struct {
+	channel string
+	done    chan struct{}
+	once    sync.Once
+	lock    sync.Mutex
+	conn    redis.Conn
+}
+
+// NewRedisTransport 基于 cache 包redis连接池的发布订阅，channel会加上 cache.Prefix 前缀
+func NewRedisTransport(channel string) WatcherTransport {
+	if channel == "" {
+		channel = defaultWatcherChannel
+	}
+	return &redisTransport{
+		channel: cache.Prefix + ":" + channel,
+		done:    make(chan struct{}),
+	}
+}
+
+func (t *redisTransport) Publish(data []byte) error {
+	conn := cache.Get()
+	defer conn.Close()
+	_, err := conn.Do("PUBLISH", t.channel, data)
+	return err
+}
+
+func (t *redisTransport) Subscribe(handler func(data []byte)) error {
+	psc, err := t.subscribe()
+	if err != nil {
+		return err
+	}
+	go t.receive(psc, handler)
+	return nil
+}
+
+func (t *redisTransport) subscribe() (redis.PubSubConn, error) {
+	psc := redis.PubSubConn{Conn: cache.Get()}
+	if err := psc.Subscribe(t.channel); err != nil {
+		_ = psc.Close()
+		return psc, err
+	}
+	t.lock.Lock()
+	t.conn = psc.Conn
+	t.lock.Unlock()
+	return psc, nil
+}
+
+// receive 订阅连接中断后每秒重试，重新订阅成功后通知handler
+func (t *redisTransport) receive(psc redis.PubSubConn, handler func(data []byte)) {
+	for {
+		stop := make(chan struct{})
+		go t.keepalive(psc, stop)
+		for err := error(nil); err == nil; {
+			switch v := psc.ReceiveWithTimeout(0).(type) {
+			case redis.Message:
+				handler(v.Data)
+			case error:
+				err = v
+			}
+		}
+		close(stop)
+		_ = psc.Close()
+
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-time.After(time.Second):
+			}
+			var err error
+			if psc, err = t.subscribe(); err == nil {
+				break
+			}
+			logger.Warn("重新订阅策略变更通知失败", err)
+		}
+		handler(nil)
+	}
+}
+
+// keepalive 定期ping以及时发现断开的连接
+func (t *redisTransport) keepalive(psc redis.PubSubConn, stop chan struct{}) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := psc.Ping(""); err != nil {
+				_ = psc.Close()
+				return
+			}
+		}
+	}
+}
+
+func (t *redisTransport) Close() error {
+	t.once.Do(func() {
+		close(t.done)
+	})
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.conn != nil {
+		return t.conn.Close()
+	}
+	return nil
+}
+
+type mqTransport struct {
+	send     func(data []byte) error
+	register func(handler func([]byte) error)
+}
+
+// NewMQTransport 使用qscore的MQ客户端传输，send发送到广播目的地，register注册该目的地的处理函数
+// 注册后需按各MQ客户端的方式开始接收(如 StartRead)，MQ客户端自行重连，重连期间的消息可能丢失
+func NewMQTransport(send func(data []byte) error, register func(handler func([]byte) error)) WatcherTransport {
+	return &mqTransport{send: send, register: register}
+}
+
+func (t *mqTransport) Publish(data []byte) error {
+	return t.send(data)
+}
+
+func (t *mqTransport) Subscribe(handler func(data []byte)) error {
+	t.register(func(data []byte) error {
+		handler(data)
+		return nil
+	})
+	return nil
+}
+
+func (t *mqTransport) Close() error {
+	return nil
+}
@@ -1,20 +1,30 @@
 package authorization
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/casbin/casbin/v2/model"
+	"xorm.io/builder"
 	"xorm.io/xorm"
 
+	"github.com/yockii/qscore/pkg/database"
 	"github.com/yockii/qscore/pkg/logger"
 	"github.com/yockii/qscore/pkg/util"
 )
 
 type adapter struct {
-	engine *xorm.Engine
+	engine xorm.EngineInterface
+	// filtered 当前加载的是否为部分策略，此时不允许 SavePolicy
+	filtered bool
+}
+
+// Filter 按租户加载策略，租户为空的全局策略(如超级管理员角色)总会加载
+type Filter struct {
+	TenantIds []string
 }
 
 type CasbinPolicy struct {
@@ -37,16 +47,83 @@ type CasbinRelationship struct {
 	TenantId        string `json:"tenantId" xorm:"comment('租户ID')"`
 }
 
-func NewAdapter(engine *xorm.Engine) (*adapter, error) {
-	if err := engine.Sync2(CasbinPolicy{}, CasbinRelationship{}); err != nil {
-		return nil, err
-	}
+func init() {
+	database.RegisterMigration(&database.Migration{
+		Version:     2021120102,
+		Description: "create casbin tables",
+		// 在迁移内声明创建时的表结构快照，结构体名决定表名，表结构变更请新增迁移
+		Up: func(session *xorm.Session) error {
+			type CasbinPolicy struct {
+				Id         string `xorm:"pk varchar(50)"`
+				PolicyType int    `xorm:"comment('策略类型 1-p, 2-p2')"`
+				SubjectId  string `xorm:"comment('策略主体ID')"`
+				Resource   string `xorm:"comment('资源内容')"`
+				Action     string `xorm:"comment('资源使用行为')"`
+				Effect     int    `xorm:"comment('策略行为 1-allow，2-deny')"`
+				Priority   int    `xorm:"comment('优先级')"`
+				TenantId   string `xorm:"comment('租户ID')"`
+				ResourceId string `xorm:"varchar(50) comment('对应的资源ID')"`
+			}
+			type CasbinRelationship struct {
+				Id              string `xorm:"pk varchar(50)"`
+				RelationType    int    `xorm:"comment('关系类型 1-g 2-g2')"`
+				SubjectId       string `xorm:"comment('主体ID')"`
+				ParentSubjectId string `xorm:"comment('继承主体ID')"`
+				TenantId        string `xorm:"comment('租户ID')"`
+			}
+			return session.Sync2(new(CasbinPolicy), new(CasbinRelationship))
+		},
+		Down: func(session *xorm.Session) error {
+			mapper := session.Engine().GetTableMapper()
+			if err := session.DropTable(mapper.Obj2Table("CasbinRelationship")); err != nil {
+				return err
+			}
+			return session.DropTable(mapper.Obj2Table("CasbinPolicy"))
+		},
+	})
+}
+
+// NewAdapter 表结构由数据库迁移创建，使用前需执行 database.MigrateUp
+func NewAdapter(engine xorm.EngineInterface) (*adapter, error) {
 	return &adapter{engine: engine}, nil
 }
 
 func (a *adapter) LoadPolicy(m model.Model) error {
+	a.filtered = false
+	return a.loadPolicy(m, builder.NewCond())
+}
+
+// LoadFilteredPolicy filter为 Filter 或 *Filter，为nil时加载全部策略
+func (a *adapter) LoadFilteredPolicy(m model.Model, filter interface{}) error {
+	var f *Filter
+	switch v := filter.(type) {
+	case nil:
+		return a.LoadPolicy(m)
+	case Filter:
+		f = &v
+	case *Filter:
+		if v == nil {
+			return a.LoadPolicy(m)
+		}
+		f = v
+	default:
+		return errors.New("不支持的策略过滤条件，需为 authorization.Filter")
+	}
+	tenantIds := append([]string{""}, f.TenantIds...)
+	if err := a.loadPolicy(m, builder.In("tenant_id", tenantIds)); err != nil {
+		return err
+	}
+	a.filtered = true
+	return nil
+}
+
+func (a *adapter) IsFiltered() bool {
+	return a.filtered
+}
+
+func (a *adapter) loadPolicy(m model.Model, cond builder.Cond) error {
 	var policies []*CasbinPolicy
-	if err := a.engine.Find(&policies); err != nil {
+	if err := a.engine.Where(cond).Find(&policies); err != nil {
 		return err
 	}
 	for _, policy := range policies {
@@ -54,26 +131,14 @@ func (a *adapter) LoadPolicy(m model.Model) error {
 		if policy.PolicyType != 1 {
 			policyType = fmt.Sprintf("p%d", policy.PolicyType)
 		}
-		effect := "allow"
-		if policy.Effect == 2 {
-			effect = "deny"
-		}
-		tokens := []string{
-			policy.SubjectId,
-			policy.Resource,
-			policy.Action,
-			effect,
-			strconv.Itoa(policy.Priority),
-			policy.TenantId,
-			policy.ResourceId,
-		}
+		tokens := policy.tokens()
 		mpp := m["p"][policyType]
 		mpp.Policy = append(mpp.Policy, tokens)
 		mpp.PolicyMap[strings.Join(tokens, model.DefaultSep)] = len(mpp.Policy) - 1
 	}
 
 	var relations []*CasbinRelationship
-	if err := a.engine.Find(&relations); err != nil {
+	if err := a.engine.Where(cond).Find(&relations); err != nil {
 		return err
 	}
 	for _, relation := range relations {
@@ -81,11 +146,7 @@ func (a *adapter) LoadPolicy(m model.Model) error {
 		if relation.RelationType != 1 {
 			relationType = fmt.Sprintf("g%d", relation.RelationType)
 		}
-		tokens := []string{
-			relation.SubjectId,
-			relation.ParentSubjectId,
-			relation.TenantId,
-		}
+		tokens := relation.tokens()
 		mgg := m["g"][relationType]
 		mgg.Policy = append(mgg.Policy, tokens)
 		mgg.PolicyMap[strings.Join(tokens, model.DefaultSep)] = len(mgg.Policy) - 1
@@ -93,13 +154,32 @@ func (a *adapter) LoadPolicy(m model.Model) error {
 	return nil
 }
 
+func (p *CasbinPolicy) tokens() []string {
+	effect := "allow"
+	if p.Effect == 2 {
+		effect = "deny"
+	}
+	return []string{
+		p.SubjectId,
+		p.Resource,
+		p.Action,
+		effect,
+		strconv.Itoa(p.Priority),
+		p.TenantId,
+		p.ResourceId,
+	}
+}
+
+func (r *CasbinRelationship) tokens() []string {
+	return []string{
+		r.SubjectId,
+		r.ParentSubjectId,
+		r.TenantId,
+	}
+}
+
 // SavePolicy saves all policy rules to the storage.
 func (a *adapter) SavePolicy(m model.Model) error {
-	sess := a.engine.NewSession()
-	defer sess.Close()
-	sess.Delete(&CasbinRelationship{})
-	sess.Delete(&CasbinPolicy{})
-
 	var policies []*CasbinPolicy
 	var roles []*CasbinRelationship
 	for policyType, ast := range m["p"] {
@@ -125,29 +205,35 @@ func (a *adapter) SavePolicy(m model.Model) error {
 		}
 	}
 
-	_, err := sess.Insert(policies)
-	if err != nil {
-		return err
-	}
-	_, err = sess.Insert(roles)
-	if err != nil {
-		return err
-	}
-
-	return sess.Commit()
+	return database.WithTxOn(context.Background(), a.engine, func(ctx context.Context) error {
+		sess := database.Session(ctx)
+		if _, err := sess.Where("1 = 1").Delete(&CasbinRelationship{}); err != nil {
+			return err
+		}
+		if _, err := sess.Where("1 = 1").Delete(&CasbinPolicy{}); err != nil {
+			return err
+		}
+		if len(policies) > 0 {
+			if _, err := sess.Insert(policies); err != nil {
+				return err
+			}
+		}
+		if len(roles) > 0 {
+			if _, err := sess.Insert(roles); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func (a *adapter) parseRelation(relationType string, rule []string) (*CasbinRelationship, error) {
 	if len(rule) != 3 {
 		return nil, errors.New("非法的父子关系规则数量，数量必须是3，实际" + strconv.Itoa(len(rule)))
 	}
-	gt := 1
-	if s := relationType[1:]; s != "" {
-		gt, _ = strconv.Atoi(relationType[1:])
-	}
 	role := &CasbinRelationship{
 		Id:              util.GenerateDatabaseID(),
-		RelationType:    gt,
+		RelationType:    typeNumber(relationType),
 		SubjectId:       rule[0],
 		ParentSubjectId: rule[1],
 		TenantId:        rule[2],
@@ -159,10 +245,6 @@ func (a *adapter) parsePolicy(policyType string, rule []string) (*CasbinPolicy,
 	if len(rule) != 7 {
 		return nil, errors.New("invalid policy rule ")
 	}
-	pt := 1
-	if s := policyType[1:]; s != "" {
-		pt, _ = strconv.Atoi(policyType[1:])
-	}
 	effect := 1
 	if rule[3] == "deny" {
 		effect = 2
@@ -170,7 +252,7 @@ func (a *adapter) parsePolicy(policyType string, rule []string) (*CasbinPolicy,
 	priority, _ := strconv.Atoi(rule[4])
 	policy := &CasbinPolicy{
 		Id:         util.GenerateDatabaseID(),
-		PolicyType: pt,
+		PolicyType: typeNumber(policyType),
 		SubjectId:  rule[0],
 		Resource:   rule[1],
 		Action:     rule[2],
@@ -182,107 +264,208 @@ func (a *adapter) parsePolicy(policyType string, rule []string) (*CasbinPolicy,
 	return policy, nil
 }
 
+// policyColumns/relationColumns p及g规则各位置对应的列
+var (
+	policyColumns   = []string{"subject_id", "resource", "action", "effect", "priority", "tenant_id", "resource_id"}
+	relationColumns = []string{"subject_id", "parent_subject_id", "tenant_id"}
+)
+
+// removeBatchSize 批量删除时每条语句包含的规则数量
+const removeBatchSize = 100
+
+func typeNumber(ptype string) int {
+	t := 1
+	if s := ptype[1:]; s != "" {
+		t, _ = strconv.Atoi(s)
+	}
+	return t
+}
+
+// ruleCond 规则对应的查询条件，exact为false时fieldValues中的空值不作为条件
+func ruleCond(sec, ptype string, fieldIndex int, fieldValues []string, exact bool) (builder.Cond, error) {
+	var columns []string
+	cond := builder.Eq{}
+	switch sec {
+	case "p":
+		columns = policyColumns
+		cond["policy_type"] = typeNumber(ptype)
+	case "g":
+		columns = relationColumns
+		cond["relation_type"] = typeNumber(ptype)
+	default:
+		return nil, errors.New("策略的sec非法! ")
+	}
+	for i, v := range fieldValues {
+		if v == "" && !exact {
+			continue
+		}
+		idx := fieldIndex + i
+		if idx < 0 || idx >= len(columns) {
+			return nil, fmt.Errorf("策略规则的位置 %d 超出范围", idx)
+		}
+		switch columns[idx] {
+		case "effect":
+			effect := 1
+			if v == "deny" {
+				effect = 2
+			}
+			cond["effect"] = effect
+		case "priority":
+			priority, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("策略优先级非法: %s", v)
+			}
+			cond["priority"] = priority
+		default:
+			cond[columns[idx]] = v
+		}
+	}
+	return cond, nil
+}
+
+func tableBean(sec string) interface{} {
+	if sec == "g" {
+		return new(CasbinRelationship)
+	}
+	return new(CasbinPolicy)
+}
+
 // AddPolicy adds a policy rule to the storage.
 func (a *adapter) AddPolicy(sec string, ptype string, rule []string) error {
-	if sec == "p" {
-		policy, err := a.parsePolicy(ptype, rule)
-		if err != nil {
-			return err
+	return a.insertRules(a.engine, sec, ptype, [][]string{rule})
+}
+
+// AddPolicies 批量添加，在同一事务中插入
+func (a *adapter) AddPolicies(sec string, ptype string, rules [][]string) error {
+	return database.WithTxOn(context.Background(), a.engine, func(ctx context.Context) error {
+		return a.insertRules(database.Session(ctx), sec, ptype, rules)
+	})
+}
+
+func (a *adapter) insertRules(conn xorm.Interface, sec string, ptype string, rules [][]string) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	switch sec {
+	case "p":
+		policies := make([]*CasbinPolicy, 0, len(rules))
+		for _, rule := range rules {
+			policy, err := a.parsePolicy(ptype, rule)
+			if err != nil {
+				return err
+			}
+			policies = append(policies, policy)
 		}
-		_, err = a.engine.InsertOne(policy)
+		_, err := conn.Insert(policies)
 		return err
-	} else if sec == "g" {
-		role, err := a.parseRelation(ptype, rule)
-		if err != nil {
-			return err
+	case "g":
+		roles := make([]*CasbinRelationship, 0, len(rules))
+		for _, rule := range rules {
+			role, err := a.parseRelation(ptype, rule)
+			if err != nil {
+				return err
+			}
+			roles = append(roles, role)
 		}
-		_, err = a.engine.InsertOne(role)
+		_, err := conn.Insert(roles)
 		return err
-	} else {
+	default:
 		return errors.New("策略添加的sec非法! ")
 	}
 }
 
 // RemovePolicy removes a policy rule from the storage.
 func (a *adapter) RemovePolicy(sec string, ptype string, rule []string) error {
-	if sec == "p" {
-		policy, err := a.parsePolicy(ptype, rule)
-		if err != nil {
-			return err
+	return a.deleteRules(a.engine, sec, ptype, [][]string{rule})
+}
+
+// RemovePolicies 批量删除，在同一事务中执行
+func (a *adapter) RemovePolicies(sec string, ptype string, rules [][]string) error {
+	return database.WithTxOn(context.Background(), a.engine, func(ctx context.Context) error {
+		return a.deleteRules(database.Session(ctx), sec, ptype, rules)
+	})
+}
+
+func (a *adapter) deleteRules(conn xorm.Interface, sec string, ptype string, rules [][]string) error {
+	for start := 0; start < len(rules); start += removeBatchSize {
+		end := start + removeBatchSize
+		if end > len(rules) {
+			end = len(rules)
 		}
-		_, err = a.engine.Delete(&policy)
-		return err
-	} else if sec == "g" {
-		role, err := a.parseRelation(ptype, rule)
-		if err != nil {
+		conds := make([]builder.Cond, 0, end-start)
+		for _, rule := range rules[start:end] {
+			cond, err := ruleCond(sec, ptype, 0, rule, true)
+			if err != nil {
+				return err
+			}
+			conds = append(conds, cond)
+		}
+		if _, err := conn.Where(builder.Or(conds...)).Delete(tableBean(sec)); err != nil {
 			return err
 		}
-		_, err = a.engine.Delete(&role)
-		return err
-	} else {
-		return errors.New("要删除的策略入参非法! ")
 	}
+	return nil
 }
 
 // RemoveFilteredPolicy removes policy rules that match the filter from the storage.
 func (a *adapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
-	idx := fieldIndex + len(fieldValues)
-	if sec == "p" {
-		pt := 1
-		if s := ptype[1:]; s != "" {
-			pt, _ = strconv.Atoi(ptype[1:])
-		}
-		policy := CasbinPolicy{
-			PolicyType: pt,
-		}
-		if fieldIndex <= 0 && idx > 0 {
-			policy.SubjectId = fieldValues[0-fieldIndex]
-		}
-		if fieldIndex <= 1 && idx > 1 {
-			policy.Resource = fieldValues[1-fieldIndex]
-		}
-		if fieldIndex <= 2 && idx > 2 {
-			policy.Action = fieldValues[2-fieldIndex]
-		}
-		if fieldIndex <= 3 && idx > 3 {
-			es := fieldValues[3-fieldIndex]
-			effect := 1
-			if es == "deny" {
-				effect = 2
-			}
-			policy.Effect = effect
-		}
-		if fieldIndex <= 3 && idx > 3 {
-			policy.Priority, _ = strconv.Atoi(fieldValues[4-fieldIndex])
-		}
-		if fieldIndex <= 4 && idx > 4 {
-			policy.TenantId = fieldValues[5-fieldIndex]
-		}
-		if fieldIndex <= 5 && idx > 5 {
-			policy.ResourceId = fieldValues[6-fieldIndex]
-		}
-		_, err := a.engine.Delete(&policy)
+	cond, err := ruleCond(sec, ptype, fieldIndex, fieldValues, false)
+	if err != nil {
 		return err
-	} else if sec == "g" {
-		gt := 1
-		if s := ptype[1:]; s != "" {
-			gt, _ = strconv.Atoi(ptype[1:])
-		}
-		role := CasbinRelationship{
-			RelationType: gt,
-		}
-		if fieldIndex <= 0 && idx > 0 {
-			role.SubjectId = fieldValues[0-fieldIndex]
+	}
+	_, err = a.engine.Where(cond).Delete(tableBean(sec))
+	return err
+}
+
+// UpdatePolicy 替换一条规则
+func (a *adapter) UpdatePolicy(sec string, ptype string, oldRule, newRule []string) error {
+	return a.UpdatePolicies(sec, ptype, [][]string{oldRule}, [][]string{newRule})
+}
+
+// UpdatePolicies 在同一事务中删除旧规则并插入新规则
+func (a *adapter) UpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) error {
+	return database.WithTxOn(context.Background(), a.engine, func(ctx context.Context) error {
+		sess := database.Session(ctx)
+		if err := a.deleteRules(sess, sec, ptype, oldRules); err != nil {
+			return err
 		}
-		if fieldIndex <= 1 && idx > 1 {
-			role.ParentSubjectId = fieldValues[1-fieldIndex]
+		return a.insertRules(sess, sec, ptype, newRules)
+	})
+}
+
+// UpdateFilteredPolicies 在同一事务中以newRules替换满足过滤条件的规则，返回被替换的规则
+func (a *adapter) UpdateFilteredPolicies(sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	cond, err := ruleCond(sec, ptype, fieldIndex, fieldValues, false)
+	if err != nil {
+		return nil, err
+	}
+	var oldRules [][]string
+	err = database.WithTxOn(context.Background(), a.engine, func(ctx context.Context) error {
+		sess := database.Session(ctx)
+		if sec == "g" {
+			var relations []*CasbinRelationship
+			if err := sess.Where(cond).Find(&relations); err != nil {
+				return err
+			}
+			for _, relation := range relations {
+				oldRules = append(oldRules, relation.tokens())
+			}
+		} else {
+			var policies []*CasbinPolicy
+			if err := sess.Where(cond).Find(&policies); err != nil {
+				return err
+			}
+			for _, policy := range policies {
+				oldRules = append(oldRules, policy.tokens())
+			}
 		}
-		if fieldIndex <= 2 && idx > 2 {
-			role.TenantId = fieldValues[2-fieldIndex]
+		if _, err := sess.Where(cond).Delete(tableBean(sec)); err != nil {
+			return err
 		}
-		_, err := a.engine.Delete(&role)
-		return err
-	} else {
-		return errors.New("要删除的策略入参非法! ")
+		return a.insertRules(sess, sec, ptype, newRules)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return oldRules, nil
 }
@@ -63,12 +63,18 @@ func (c *config) GetStringSlice(key string) []string {
 func (c *config) GetStringMapString(key string) map[string]string {
 	return c.Viper.GetStringMapString(key)
 }
+func (c *config) GetStringMap(key string) map[string]interface{} {
+	return c.Viper.GetStringMap(key)
+}
 func (c *config) GetDuration(key string) time.Duration {
 	return c.Viper.GetDuration(key)
 }
 func (c *config) WatchConfig() {
 	c.Viper.WatchConfig()
 }
+func (c *config) UnmarshalKey(key string, rawVal interface{}) error {
+	return c.Viper.UnmarshalKey(key, rawVal)
+}
 
 /////////////////////////////////////////////////////////////////////
 //////// 默认配置获取 //////
@@ -116,9 +122,15 @@ func GetIntSlice(key string) []int {
 func GetStringMapString(key string) map[string]string {
 	return defaultConfig.GetStringMapString(key)
 }
+func GetStringMap(key string) map[string]interface{} {
+	return defaultConfig.GetStringMap(key)
+}
 func GetDuration(key string) time.Duration {
 	return defaultConfig.GetDuration(key)
 }
 func WatchConfig() {
 	defaultConfig.WatchConfig()
 }
+func UnmarshalKey(key string, rawVal interface{}) error {
+	return defaultConfig.UnmarshalKey(key, rawVal)
+}
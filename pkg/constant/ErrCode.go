@@ -9,4 +9,5 @@ const (
 	ErrorCodeDuplicate
 	ErrorCodeInvalid
 	ErrorCodeReject
+	ErrorCodeConflict
 )
@@ -1,6 +1,5 @@
 package constant
 
 const (
-	JWT_SECRET  = "293jfldsa9fjdvnadaksfjaserf"
 	JWT_CONTEXT = "jwt-subject"
 )
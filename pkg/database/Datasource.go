@@ -0,0 +1,265 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"xorm.io/xorm"
+
+	"github.com/yockii/qscore/pkg/config"
+)
+
+// 具名数据源及读写分离，配置示例：
+//
+//	database:
+//	  driver: mysql          # 默认数据源，即 DB
+//	  host: 127.0.0.1
+//	  replicas:              # 默认数据源的只读从库，未配置的项继承主库配置
+//	    - host: 127.0.0.2
+//	  report:                # 具名数据源 report，database.<name>下配置了driver或dsn即视为数据源
+//	    driver: pg
+//	    host: 127.0.0.3
+//	    port: 5432
+//	    user: report
+//	    password: xxx
+//	    db: report
+//	    policy: weightRoundRobin
+//	    replicas:
+//	      - host: 127.0.0.4
+//	        weight: 2
+//	      - host: 127.0.0.5
+//	        weight: 1
+//
+// policy可选 random(默认)、weightRandom、roundRobin、weightRoundRobin、leastConn
+// 读操作路由到从库，写操作及事务使用主库
+
+const DefaultDatasource = "default"
+
+type DatasourceConfig struct {
+	Driver   string `mapstructure:"driver"`
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Db       string `mapstructure:"db"`
+	// Dsn 完整连接串，配置后忽略host等配置，Driver需为驱动名
+	Dsn      string              `mapstructure:"dsn"`
+	Prefix   string              `mapstructure:"prefix"`
+	ShowSql  bool                `mapstructure:"showSql"`
+	Policy   string              `mapstructure:"policy"`
+	Weight   int                 `mapstructure:"weight"`
+	Replicas []*DatasourceConfig `mapstructure:"replicas"`
+}
+
+var (
+	datasourceLock sync.RWMutex
+	datasources    = make(map[string]*xorm.EngineGroup)
+)
+
+// inherit 从库未配置的项继承主库配置
+func (c *DatasourceConfig) inherit(primary *DatasourceConfig) *DatasourceConfig {
+	r := *c
+	if r.Driver == "" {
+		r.Driver = primary.Driver
+	}
+	if r.Dsn == "" {
+		if r.Host == "" {
+			r.Host = primary.Host
+		}
+		if r.Port == 0 {
+			r.Port = primary.Port
+		}
+		if r.User == "" {
+			r.User = primary.User
+		}
+		if r.Password == "" {
+			r.Password = primary.Password
+		}
+		if r.Db == "" {
+			r.Db = primary.Db
+		}
+	}
+	if r.Prefix == "" {
+		r.Prefix = primary.Prefix
+	}
+	r.ShowSql = r.ShowSql || primary.ShowSql
+	return &r
+}
+
+func (c *DatasourceConfig) open() (*xorm.Engine, error) {
+	var engine *xorm.Engine
+	var err error
+	if c.Dsn != "" {
+		engine, err = initDBWithDefine(c.Driver, c.Dsn)
+	} else {
+		engine, err = initDB(c.Driver, c.Host, c.User, c.Password, c.Db, c.Port)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = engine.Ping(); err != nil {
+		_ = engine.Close()
+		return nil, err
+	}
+	setupEngine(engine, c.Prefix, c.ShowSql, config.GetString("log.level"))
+	return engine, nil
+}
+
+func (c *DatasourceConfig) policy() (xorm.GroupPolicy, error) {
+	weights := make([]int, 0, len(c.Replicas))
+	for _, r := range c.Replicas {
+		w := r.Weight
+		if w <= 0 {
+			w = 1
+		}
+		weights = append(weights, w)
+	}
+	switch strings.ToLower(c.Policy) {
+	case "", "random":
+		return xorm.RandomPolicy(), nil
+	case "weightrandom":
+		return xorm.WeightRandomPolicy(weights), nil
+	case "roundrobin":
+		return xorm.RoundRobinPolicy(), nil
+	case "weightroundrobin":
+		return xorm.WeightRoundRobinPolicy(weights), nil
+	case "leastconn":
+		return xorm.LeastConnPolicy(), nil
+	}
+	return nil, fmt.Errorf("不支持的从库路由策略 %s", c.Policy)
+}
+
+// newGroup master为nil时按配置连接主库
+func newGroup(c *DatasourceConfig, master *xorm.Engine) (*xorm.EngineGroup, error) {
+	var err error
+	if master == nil {
+		if master, err = c.open(); err != nil {
+			return nil, err
+		}
+	}
+	policy, err := c.policy()
+	if err != nil {
+		return nil, err
+	}
+	slaves := make([]*xorm.Engine, 0, len(c.Replicas))
+	for _, r := range c.Replicas {
+		slave, err := r.inherit(c).open()
+		if err != nil {
+			for _, s := range slaves {
+				_ = s.Close()
+			}
+			return nil, err
+		}
+		slaves = append(slaves, slave)
+	}
+	return xorm.NewEngineGroup(master, slaves, policy)
+}
+
+// initDefaultGroup 为默认数据源 DB 创建引擎组，database.replicas 为其从库
+func initDefaultGroup() error {
+	c := new(DatasourceConfig)
+	if err := config.UnmarshalKey("database", c); err != nil {
+		return err
+	}
+	group, err := newGroup(c, DB)
+	if err != nil {
+		return err
+	}
+	datasourceLock.Lock()
+	datasources[DefaultDatasource] = group
+	datasourceLock.Unlock()
+	return nil
+}
+
+// InitDatasources 初始化 database.<name> 下配置的所有具名数据源，InitSysDB 会自动调用
+func InitDatasources() error {
+	for name, v := range config.GetStringMap("database") {
+		sub, ok := v.(map[string]interface{})
+		if !ok || name == "replicas" {
+			continue
+		}
+		if _, hasDriver := sub["driver"]; !hasDriver {
+			if _, hasDsn := sub["dsn"]; !hasDsn {
+				continue
+			}
+		}
+		if err := InitDatasource(name); err != nil {
+			return fmt.Errorf("数据源 %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// InitDatasource 按配置 database.<name> 初始化数据源，已存在时关闭并替换
+func InitDatasource(name string) error {
+	c := new(DatasourceConfig)
+	if err := config.UnmarshalKey("database."+name, c); err != nil {
+		return err
+	}
+	group, err := newGroup(c, nil)
+	if err != nil {
+		return err
+	}
+	RegisterDatasource(name, group)
+	return nil
+}
+
+// RegisterDatasource 注册自行创建的数据源，已存在时关闭并替换
+func RegisterDatasource(name string, group *xorm.EngineGroup) {
+	datasourceLock.Lock()
+	old := datasources[name]
+	datasources[name] = group
+	datasourceLock.Unlock()
+	if old != nil && name != DefaultDatasource {
+		_ = old.Close()
+	}
+}
+
+// Datasource 获取具名数据源，name为空时为默认数据源，不存在时返回nil
+func Datasource(name string) *xorm.EngineGroup {
+	if name == "" {
+		name = DefaultDatasource
+	}
+	datasourceLock.RLock()
+	defer datasourceLock.RUnlock()
+	return datasources[name]
+}
+
+// Engine 获取数据源，供需要数据库访问的组件使用，name为空或默认数据源未创建引擎组时返回 DB
+// 不存在的具名数据源返回nil
+func Engine(name string) xorm.EngineInterface {
+	if g := Datasource(name); g != nil {
+		return g
+	}
+	// DB未初始化时返回nil本身，避免返回包装了nil指针的非nil接口
+	if (name == "" || name == DefaultDatasource) && DB != nil {
+		return DB
+	}
+	return nil
+}
+
+// ReadEngine 获取只读查询使用的引擎，数据源配置了从库时按策略选取从库，否则与 Engine 相同
+// 从库存在复制延迟，需读取刚写入的数据时请使用 Engine
+func ReadEngine(name string) xorm.EngineInterface {
+	if g := Datasource(name); g != nil {
+		return g.Slave()
+	}
+	return Engine(name)
+}
+
+func closeDatasources() {
+	datasourceLock.Lock()
+	defer datasourceLock.Unlock()
+	for name, g := range datasources {
+		// 默认数据源的主库 DB 由 Close 关闭
+		if name == DefaultDatasource {
+			for _, s := range g.Slaves() {
+				_ = s.Close()
+			}
+		} else {
+			_ = g.Close()
+		}
+		delete(datasources, name)
+	}
+}
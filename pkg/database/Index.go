@@ -7,6 +7,7 @@ import (
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 	"xorm.io/xorm"
 	"xorm.io/xorm/log"
 	"xorm.io/xorm/names"
@@ -18,6 +19,7 @@ import (
 const (
 	mysqlConnStringFmt = "%s:%s@tcp(%s:%d)/%s"
 	pgConnStringFmt    = "host=%s port=%d user=%s password=%s dbname=%s sslmode=disable"
+	sqliteMemory       = ":memory:"
 )
 
 var DB *xorm.Engine
@@ -31,28 +33,7 @@ func InitDB(dbType, host, user, password, dbName string, port int, prefix string
 	if err = DB.Ping(); err != nil {
 		logger.Fatalf("数据库连接失败! %v", err)
 	}
-	if prefix != "" {
-		DB.SetTableMapper(names.NewPrefixMapper(names.SnakeMapper{}, prefix))
-	}
-
-	if showSql {
-		DB.ShowSQL(true)
-	}
-	if logLevel != "" {
-		switch strings.ToLower(logLevel) {
-		case "error":
-			DB.SetLogLevel(log.LOG_ERR)
-		case "warn":
-			DB.SetLogLevel(log.LOG_WARNING)
-		case "info":
-			DB.SetLogLevel(log.LOG_INFO)
-		case "debug":
-			DB.SetLogLevel(log.LOG_DEBUG)
-		default:
-			DB.SetLogLevel(log.LOG_OFF)
-		}
-	}
-
+	setupEngine(DB, prefix, showSql, logLevel)
 }
 
 func InitDB2(dbDriver, datasource, prefix string, showSql bool, logLevel string) {
@@ -64,25 +45,29 @@ func InitDB2(dbDriver, datasource, prefix string, showSql bool, logLevel string)
 	if err = DB.Ping(); err != nil {
 		logger.Fatalf("数据库连接失败! %v", err)
 	}
+	setupEngine(DB, prefix, showSql, logLevel)
+}
+
+func setupEngine(engine *xorm.Engine, prefix string, showSql bool, logLevel string) {
 	if prefix != "" {
-		DB.SetTableMapper(names.NewPrefixMapper(names.SnakeMapper{}, prefix))
+		engine.SetTableMapper(names.NewPrefixMapper(names.SnakeMapper{}, prefix))
 	}
 
 	if showSql {
-		DB.ShowSQL(true)
+		engine.ShowSQL(true)
 	}
 	if logLevel != "" {
 		switch strings.ToLower(logLevel) {
 		case "error":
-			DB.SetLogLevel(log.LOG_ERR)
+			engine.SetLogLevel(log.LOG_ERR)
 		case "warn":
-			DB.SetLogLevel(log.LOG_WARNING)
+			engine.SetLogLevel(log.LOG_WARNING)
 		case "info":
-			DB.SetLogLevel(log.LOG_INFO)
+			engine.SetLogLevel(log.LOG_INFO)
 		case "debug":
-			DB.SetLogLevel(log.LOG_DEBUG)
+			engine.SetLogLevel(log.LOG_DEBUG)
 		default:
-			DB.SetLogLevel(log.LOG_OFF)
+			engine.SetLogLevel(log.LOG_OFF)
 		}
 	}
 }
@@ -99,6 +84,22 @@ func InitSysDB() {
 		config.GetBool("database.showSql"),
 		config.GetString("log.level"),
 	)
+	if err := initDefaultGroup(); err != nil {
+		logger.Fatalf("数据库从库连接失败! %v", err)
+	}
+	if err := InitDatasources(); err != nil {
+		logger.Fatalf("数据源初始化失败! %v", err)
+	}
+	// database.autoMigrate 为true时启动即执行数据库迁移，默认不执行，需自行调用 MigrateUp
+	if config.GetBool("database.autoMigrate") {
+		if err := MigrateUp(); err != nil {
+			logger.Fatalf("数据库迁移失败! %v", err)
+		}
+	} else if pending, err := PendingMigrations(); err != nil {
+		logger.Warnf("获取数据库迁移状态失败: %v", err)
+	} else if len(pending) > 0 {
+		logger.Warnf("存在未执行的数据库迁移 %v，请调用 database.MigrateUp 或将 database.autoMigrate 配置为true", pending)
+	}
 }
 
 func initDBWithDefine(driverName, datasourceName string) (*xorm.Engine, error) {
@@ -124,12 +125,30 @@ func initDB(dbType string, host string, user string, password string, dbName str
 			password,
 			dbName,
 		))
+	} else if dbType == "sqlite" || dbType == "sqlite3" {
+		return initSqlite(dbName)
 	} else {
 		logger.Errorf("数据库初始化失败, 不支持的数据库类型! type=%s, host=%s, user=%s, pwd=%s, db=%s, port=%d", dbType, host, user, password, dbName, port)
 		return nil, errors.New("数据库初始化失败, 不支持的数据库类型")
 	}
 }
 
+// initSqlite 使用纯Go实现的sqlite驱动，dbName为数据库文件路径，为空或 :memory: 时使用内存数据库(关闭后数据丢失)
+// 内存数据库仅存在于单个连接中，且sqlite同一时间只允许一个写入者，因此限制连接数为1
+// 注意持有事务时不要再通过引擎(而非事务session)访问数据库，否则会互相等待
+func initSqlite(dbName string) (*xorm.Engine, error) {
+	if dbName == "" {
+		dbName = sqliteMemory
+	}
+	engine, err := initDBWithDefine("sqlite", dbName)
+	if err != nil {
+		return nil, err
+	}
+	engine.SetMaxOpenConns(1)
+	return engine, nil
+}
+
 func Close() {
+	closeDatasources()
 	_ = DB.Close()
 }
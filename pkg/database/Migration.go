@@ -0,0 +1,436 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"xorm.io/xorm"
+	"xorm.io/xorm/names"
+
+	"github.com/yockii/qscore/pkg/config"
+	"github.com/yockii/qscore/pkg/logger"
+)
+
+// 版本化的数据库迁移，已执行的版本记录在 schema_version 表中，schema_lock 表用于多实例间互斥
+// 每个迁移在单独的事务中执行，注意MySQL的DDL语句会隐式提交，失败时无法回滚已执行的DDL
+// SQL迁移中的 ${prefix} 会被替换为配置的表前缀，多条语句以行尾的分号分隔
+
+// Migration Up/UpSQL 二选一，Down/DownSQL 可为空，为空时该迁移不可回滚
+type Migration struct {
+	Version     int64
+	Description string
+	Up          func(session *xorm.Session) error
+	Down        func(session *xorm.Session) error
+	UpSQL       string
+	DownSQL     string
+}
+
+// MigrationState 迁移状态，Registered为false表示数据库中已执行但当前程序未注册的版本
+type MigrationState struct {
+	Version     int64     `json:"version"`
+	Description string    `json:"description"`
+	Applied     bool      `json:"applied"`
+	AppliedAt   time.Time `json:"appliedAt,omitempty"`
+	Registered  bool      `json:"registered"`
+}
+
+type SchemaVersion struct {
+	Version     int64     `xorm:"pk bigint"`
+	Description string    `xorm:"varchar(255)"`
+	AppliedAt   time.Time `xorm:"datetime"`
+}
+
+type SchemaLock struct {
+	Id       int       `xorm:"pk"`
+	Locked   int       `xorm:"notnull default 0"`
+	Owner    string    `xorm:"varchar(100)"`
+	LockedAt time.Time `xorm:"datetime"`
+}
+
+const schemaLockId = 1
+
+var (
+	ErrMigrationLocked       = errors.New("获取数据库迁移锁超时，可能有其他实例正在执行迁移")
+	ErrMigrationIrreversible = errors.New("迁移不可回滚")
+)
+
+var (
+	migrationLock sync.Mutex
+	migrations    = make(map[int64]*Migration)
+)
+
+// RegisterMigration 注册迁移，版本号重复时panic，建议使用 年月日+序号 形式的版本号，如 2021120101
+func RegisterMigration(ms ...*Migration) {
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+	for _, m := range ms {
+		if _, ok := migrations[m.Version]; ok {
+			panic(fmt.Sprintf("数据库迁移版本重复: %d", m.Version))
+		}
+		if m.Up == nil && m.UpSQL == "" {
+			panic(fmt.Sprintf("数据库迁移 %d 缺少Up", m.Version))
+		}
+		migrations[m.Version] = m
+	}
+}
+
+// RegisterSQLMigrations 从fsys的dir目录加载SQL迁移，文件名格式为 <版本>_<描述>.up.sql 及 <版本>_<描述>.down.sql
+// 配合 embed 使用：//go:embed migrations/*.sql
+func RegisterSQLMigrations(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[int64]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".sql")
+		up := strings.HasSuffix(base, ".up")
+		if !up && !strings.HasSuffix(base, ".down") {
+			return fmt.Errorf("迁移文件 %s 需以 .up.sql 或 .down.sql 结尾", name)
+		}
+		base = strings.TrimSuffix(strings.TrimSuffix(base, ".up"), ".down")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("迁移文件 %s 版本号非法: %v", name, err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		m, ok := loaded[version]
+		if !ok {
+			m = &Migration{Version: version}
+			if len(parts) == 2 {
+				m.Description = strings.ReplaceAll(parts[1], "_", " ")
+			}
+			loaded[version] = m
+		}
+		if up {
+			m.UpSQL = string(content)
+		} else {
+			m.DownSQL = string(content)
+		}
+	}
+	ms := make([]*Migration, 0, len(loaded))
+	for _, m := range loaded {
+		ms = append(ms, m)
+	}
+	RegisterMigration(ms...)
+	return nil
+}
+
+func sortedMigrations() []*Migration {
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+	ms := make([]*Migration, 0, len(migrations))
+	for _, m := range migrations {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Version < ms[j].Version
+	})
+	return ms
+}
+
+// Migrator 在指定数据库上执行迁移
+type Migrator struct {
+	engine *xorm.Engine
+	// LockTimeout 等待迁移锁的最长时间，超时返回 ErrMigrationLocked
+	LockTimeout time.Duration
+	// LockStale 持有者每 LockStale/4 刷新一次锁，超过该时间未刷新的锁视为持有者已退出，可被其他实例获取
+	LockStale time.Duration
+}
+
+func NewMigrator(engine *xorm.Engine) *Migrator {
+	timeout := config.GetDuration("database.migrationLockTimeout")
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	stale := config.GetDuration("database.migrationLockStale")
+	if stale <= 0 {
+		stale = 2 * time.Minute
+	}
+	return &Migrator{engine: engine, LockTimeout: timeout, LockStale: stale}
+}
+
+func (m *Migrator) prepare() error {
+	if err := m.engine.Sync2(new(SchemaVersion), new(SchemaLock)); err != nil {
+		return err
+	}
+	has, err := m.engine.ID(schemaLockId).Exist(new(SchemaLock))
+	if err != nil || has {
+		return err
+	}
+	if _, err = m.engine.Insert(&SchemaLock{Id: schemaLockId, LockedAt: time.Now()}); err != nil {
+		// 其他实例可能已插入
+		if has, _ = m.engine.ID(schemaLockId).Exist(new(SchemaLock)); !has {
+			return err
+		}
+	}
+	return nil
+}
+
+// lock 通过条件更新获取迁移锁，持有期间定期刷新 locked_at，返回释放函数
+func (m *Migrator) lock() (func(), error) {
+	owner := migrationOwner()
+	deadline := time.Now().Add(m.LockTimeout)
+	for {
+		now := time.Now()
+		affected, err := m.engine.Where("id = ? AND (locked = 0 OR locked_at < ?)", schemaLockId, now.Add(-m.LockStale)).
+			Cols("locked", "owner", "locked_at").
+			Update(&SchemaLock{Locked: 1, Owner: owner, LockedAt: now})
+		if err != nil {
+			return nil, err
+		}
+		if affected == 1 {
+			stop, stopped := make(chan struct{}), make(chan struct{})
+			go m.heartbeat(owner, stop, stopped)
+			return func() {
+				close(stop)
+				<-stopped
+				if _, err := m.engine.Where("id = ? AND owner = ?", schemaLockId, owner).
+					Cols("locked").Update(&SchemaLock{Locked: 0}); err != nil {
+					logger.Errorf("释放数据库迁移锁失败: %v", err)
+				}
+			}, nil
+		}
+		if time.Now().Add(time.Second).After(deadline) {
+			return nil, ErrMigrationLocked
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// heartbeat 定期刷新锁的 locked_at，避免执行时间较长的迁移被视为失效
+func (m *Migrator) heartbeat(owner string, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(m.LockStale / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			affected, err := m.engine.Where("id = ? AND owner = ?", schemaLockId, owner).
+				Cols("locked_at").Update(&SchemaLock{LockedAt: time.Now()})
+			if err != nil {
+				logger.Warnf("刷新数据库迁移锁失败: %v", err)
+			} else if affected == 0 {
+				logger.Errorf("数据库迁移锁已被其他实例获取，请检查 database.migrationLockStale 配置")
+			}
+		}
+	}
+}
+
+func migrationOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+func (m *Migrator) applied() (map[int64]*SchemaVersion, error) {
+	var versions []*SchemaVersion
+	if err := m.engine.Find(&versions); err != nil {
+		return nil, err
+	}
+	rst := make(map[int64]*SchemaVersion, len(versions))
+	for _, v := range versions {
+		rst[v.Version] = v
+	}
+	return rst, nil
+}
+
+// Up 按版本顺序执行所有未执行的迁移
+func (m *Migrator) Up() error {
+	if err := m.prepare(); err != nil {
+		return err
+	}
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for _, mg := range sortedMigrations() {
+		if _, ok := applied[mg.Version]; ok {
+			continue
+		}
+		logger.Infof("执行数据库迁移 %d %s", mg.Version, mg.Description)
+		if err = m.run(mg, true); err != nil {
+			return fmt.Errorf("数据库迁移 %d 执行失败: %v", mg.Version, err)
+		}
+	}
+	return nil
+}
+
+// Down 回滚最近执行的steps个迁移
+func (m *Migrator) Down(steps int) error {
+	if err := m.prepare(); err != nil {
+		return err
+	}
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	ms := sortedMigrations()
+	for i := len(ms) - 1; i >= 0 && steps > 0; i-- {
+		mg := ms[i]
+		if _, ok := applied[mg.Version]; !ok {
+			continue
+		}
+		if mg.Down == nil && mg.DownSQL == "" {
+			return fmt.Errorf("数据库迁移 %d: %w", mg.Version, ErrMigrationIrreversible)
+		}
+		logger.Infof("回滚数据库迁移 %d %s", mg.Version, mg.Description)
+		if err = m.run(mg, false); err != nil {
+			return fmt.Errorf("数据库迁移 %d 回滚失败: %v", mg.Version, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+func (m *Migrator) Status() ([]*MigrationState, error) {
+	if err := m.prepare(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var states []*MigrationState
+	for _, mg := range sortedMigrations() {
+		s := &MigrationState{Version: mg.Version, Description: mg.Description, Registered: true}
+		if v, ok := applied[mg.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = v.AppliedAt
+			delete(applied, mg.Version)
+		}
+		states = append(states, s)
+	}
+	for _, v := range applied {
+		states = append(states, &MigrationState{Version: v.Version, Description: v.Description, Applied: true, AppliedAt: v.AppliedAt})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Version < states[j].Version
+	})
+	return states, nil
+}
+
+func (m *Migrator) run(mg *Migration, up bool) error {
+	session := m.engine.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return err
+	}
+	var err error
+	if up {
+		if mg.Up != nil {
+			err = mg.Up(session)
+		} else {
+			err = m.execSQL(session, mg.UpSQL)
+		}
+		if err == nil {
+			_, err = session.Insert(&SchemaVersion{Version: mg.Version, Description: mg.Description, AppliedAt: time.Now()})
+		}
+	} else {
+		if mg.Down != nil {
+			err = mg.Down(session)
+		} else {
+			err = m.execSQL(session, mg.DownSQL)
+		}
+		if err == nil {
+			_, err = session.ID(mg.Version).Delete(new(SchemaVersion))
+		}
+	}
+	if err != nil {
+		_ = session.Rollback()
+		return err
+	}
+	return session.Commit()
+}
+
+func (m *Migrator) execSQL(session *xorm.Session, content string) error {
+	prefix := ""
+	if pm, ok := m.engine.GetTableMapper().(names.PrefixMapper); ok {
+		prefix = pm.Prefix
+	}
+	content = strings.ReplaceAll(content, "${prefix}", prefix)
+	for _, stmt := range splitSQL(content) {
+		if _, err := session.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitSQL 按行尾分号拆分语句，忽略空行及 -- 开头的注释行
+func splitSQL(content string) []string {
+	var stmts []string
+	var sb strings.Builder
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		sb.WriteString(line)
+		sb.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSpace(sb.String()))
+			sb.Reset()
+		}
+	}
+	if s := strings.TrimSpace(sb.String()); s != "" {
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
+
+func MigrateUp() error {
+	return NewMigrator(DB).Up()
+}
+
+func MigrateDown(steps int) error {
+	return NewMigrator(DB).Down(steps)
+}
+
+// PendingMigrations 已注册但未执行的迁移版本
+func PendingMigrations() ([]int64, error) {
+	states, err := MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+	var pending []int64
+	for _, s := range states {
+		if s.Registered && !s.Applied {
+			pending = append(pending, s.Version)
+		}
+	}
+	return pending, nil
+}
+
+func MigrationStatus() ([]*MigrationState, error) {
+	return NewMigrator(DB).Status()
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"xorm.io/builder"
+	"xorm.io/xorm"
+)
+
+// 租户数据隔离，模型实现 TenantScoped(嵌入 domain.TenantModel)即为租户数据表：
+//
+//	ctx = database.WithTenant(ctx, tenantId)
+//	session, err := database.Scoped(ctx, new(Customer))   // 追加 tenant_id = ? 条件
+//	if err != nil {
+//		return err
+//	}
+//	has, err := session.ID(id).Get(&customer)
+//
+//	err = database.FillTenant(ctx, customer)              // 新增前填充租户ID
+//
+// ctx中没有租户时返回 ErrTenantRequired 而不是查询全部数据；跨租户操作需显式使用 WithCrossTenant
+// 条件中的列名为 tenant_id，联表查询时请自行处理列名歧义
+
+const TenantColumn = "tenant_id"
+
+var ErrTenantRequired = errors.New("租户数据表操作缺少租户信息")
+
+// TenantScoped 租户数据表模型
+type TenantScoped interface {
+	GetTenantId() string
+	SetTenantId(tenantId string)
+}
+
+type tenantKey struct{}
+
+type crossTenantKey struct{}
+
+var tenantScopedType = reflect.TypeOf((*TenantScoped)(nil)).Elem()
+
+// WithTenant 在ctx中记录当前租户
+func WithTenant(ctx context.Context, tenantId string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, tenantKey{}, tenantId)
+}
+
+// TenantFrom 获取ctx中的租户，租户为空视为不存在
+func TenantFrom(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	tenantId, _ := ctx.Value(tenantKey{}).(string)
+	return tenantId, tenantId != ""
+}
+
+// WithCrossTenant 允许跨租户操作，查询不再追加租户条件，新增时保留模型中的租户ID
+// 仅用于超级管理员等需要访问全部租户数据的场景，调用方负责权限校验
+func WithCrossTenant(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, crossTenantKey{}, true)
+}
+
+// IsCrossTenant ctx是否允许跨租户操作
+func IsCrossTenant(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	cross, _ := ctx.Value(crossTenantKey{}).(bool)
+	return cross
+}
+
+// IsTenantScoped bean为模型、模型指针或模型切片(指针)时判断是否为租户数据表
+func IsTenantScoped(bean interface{}) bool {
+	if bean == nil {
+		return false
+	}
+	t := reflect.TypeOf(bean)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Map {
+		t = t.Elem()
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	return t.Implements(tenantScopedType) || reflect.PtrTo(t).Implements(tenantScopedType)
+}
+
+// TenantCond bean为租户数据表时返回租户条件，非租户数据表或跨租户时返回空条件
+func TenantCond(ctx context.Context, bean interface{}) (builder.Cond, error) {
+	if !IsTenantScoped(bean) || IsCrossTenant(ctx) {
+		return builder.NewCond(), nil
+	}
+	tenantId, ok := TenantFrom(ctx)
+	if !ok {
+		return nil, ErrTenantRequired
+	}
+	return builder.Eq{TenantColumn: tenantId}, nil
+}
+
+// ApplyTenant 为session追加bean对应的租户条件，用于需要执行多次查询的session
+func ApplyTenant(ctx context.Context, session *xorm.Session, bean interface{}) error {
+	cond, err := TenantCond(ctx, bean)
+	if err != nil {
+		return err
+	}
+	if cond.IsValid() {
+		session.And(cond)
+	}
+	return nil
+}
+
+// Scoped 在事务session(不在事务中时为默认数据源)上追加租户条件，返回的session只可执行一次操作
+func Scoped(ctx context.Context, bean interface{}) (*xorm.Session, error) {
+	return ScopedOn(ctx, Conn(ctx), bean)
+}
+
+// ScopedOn 在指定数据源或session上追加租户条件
+func ScopedOn(ctx context.Context, conn xorm.Interface, bean interface{}) (*xorm.Session, error) {
+	cond, err := TenantCond(ctx, bean)
+	if err != nil {
+		return nil, err
+	}
+	return conn.Where(cond), nil
+}
+
+// FillTenant 以ctx中的租户覆盖租户数据表模型的租户ID，跨租户时保留模型中的值
+func FillTenant(ctx context.Context, beans ...interface{}) error {
+	if IsCrossTenant(ctx) {
+		return nil
+	}
+	for _, bean := range beans {
+		if !IsTenantScoped(bean) {
+			continue
+		}
+		tenantId, ok := TenantFrom(ctx)
+		if !ok {
+			return ErrTenantRequired
+		}
+		if err := fillTenant(reflect.ValueOf(bean), tenantId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fillTenant(v reflect.Value, tenantId string) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if m, ok := v.Interface().(TenantScoped); ok && v.Kind() == reflect.Ptr {
+			m.SetTenantId(tenantId)
+			return nil
+		}
+		return fillTenant(v.Elem(), tenantId)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := fillTenant(v.Index(i), tenantId); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		if v.CanAddr() {
+			return fillTenant(v.Addr(), tenantId)
+		}
+	}
+	return errors.New("租户数据表模型需以指针传入")
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"xorm.io/xorm"
+
+	"github.com/yockii/qscore/pkg/config"
+	"github.com/yockii/qscore/pkg/logger"
+)
+
+// 事务辅助，事务session通过context传递：
+//
+//	err := database.WithTx(ctx, func(ctx context.Context) error {
+//		if _, err := database.Session(ctx).Insert(user); err != nil {
+//			return err
+//		}
+//		return saveRoles(ctx, user) // 内部同样使用 database.Session(ctx) 或嵌套 WithTx
+//	})
+//
+// fn返回错误或panic时回滚，否则提交；嵌套调用使用保存点，内层失败只回滚到保存点
+// 最外层事务遇到死锁/序列化失败时会重试整个fn，重试次数由 database.txRetries 配置(默认3次)，fn需保证可重复执行
+
+type txKey struct{}
+
+type txState struct {
+	engine  xorm.EngineInterface
+	session *xorm.Session
+	depth   int
+}
+
+// WithTx 在默认数据源上执行事务
+func WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return WithTxOn(ctx, Engine(""), fn)
+}
+
+// WithTxOn 在指定数据源上执行事务，ctx中已有同一数据源的事务时创建保存点
+func WithTxOn(ctx context.Context, engine xorm.EngineInterface, fn func(ctx context.Context) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if state, ok := ctx.Value(txKey{}).(*txState); ok && state.engine == engine {
+		return withSavepoint(ctx, state, fn)
+	}
+
+	retries := config.GetInt("database.txRetries")
+	if !config.IsSet("database.txRetries") {
+		retries = 3
+	}
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, engine, fn)
+		if err == nil || attempt >= retries || !IsRetryableTxError(err) {
+			return err
+		}
+		backoff := time.Duration(attempt+1)*50*time.Millisecond + time.Duration(rand.Intn(50))*time.Millisecond
+		logger.Warnf("事务冲突，%v 后第%d次重试: %v", backoff, attempt+1, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func runTx(ctx context.Context, engine xorm.EngineInterface, fn func(ctx context.Context) error) (err error) {
+	session := engine.NewSession().Context(ctx)
+	defer session.Close()
+	if err = session.Begin(); err != nil {
+		return err
+	}
+	state := &txState{engine: engine, session: session}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = session.Rollback()
+			panic(p)
+		}
+	}()
+	if err = fn(context.WithValue(ctx, txKey{}, state)); err != nil {
+		if rbErr := session.Rollback(); rbErr != nil {
+			logger.Errorf("事务回滚失败: %v", rbErr)
+		}
+		return err
+	}
+	return session.Commit()
+}
+
+func withSavepoint(ctx context.Context, state *txState, fn func(ctx context.Context) error) (err error) {
+	state.depth++
+	defer func() { state.depth-- }()
+	name := fmt.Sprintf("qs_sp_%d", state.depth)
+	if _, err = state.session.Exec("SAVEPOINT " + name); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = state.session.Exec("ROLLBACK TO SAVEPOINT " + name)
+			panic(p)
+		}
+	}()
+	if err = fn(ctx); err != nil {
+		if _, rbErr := state.session.Exec("ROLLBACK TO SAVEPOINT " + name); rbErr != nil {
+			logger.Errorf("回滚到保存点 %s 失败: %v", name, rbErr)
+		}
+		return err
+	}
+	_, err = state.session.Exec("RELEASE SAVEPOINT " + name)
+	return err
+}
+
+// Session 获取ctx中的事务session，不在事务中时返回nil
+func Session(ctx context.Context) *xorm.Session {
+	if ctx == nil {
+		return nil
+	}
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return state.session
+	}
+	return nil
+}
+
+// Conn 在事务中时返回事务session，否则返回默认数据源，便于编写既可在事务内也可在事务外调用的方法
+func Conn(ctx context.Context) xorm.Interface {
+	if session := Session(ctx); session != nil {
+		return session
+	}
+	return Engine("")
+}
+
+// IsRetryableTxError 是否为死锁、锁等待超时或序列化失败等可重试的错误
+func IsRetryableTxError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// 1213 死锁 1205 锁等待超时
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// 40P01 死锁 40001 序列化失败
+		return pqErr.Code == "40P01" || pqErr.Code == "40001"
+	}
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "deadlock") || strings.Contains(msg, "database is locked")
+}
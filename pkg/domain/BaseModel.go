@@ -0,0 +1,57 @@
+package domain
+
+// BaseModel 通用审计字段，以 xorm:"extends" 嵌入模型：
+//
+//	type Customer struct {
+//		Id        string `json:"id,omitempty" xorm:"pk varchar(50)"`
+//		BaseModel `xorm:"extends"`
+//	}
+//
+// UpdateTime 由xorm在更新时自动填充；DeleteTime 为软删除标记，xorm的Delete改为更新该字段，查询时自动排除已删除数据
+// Version 为乐观锁版本号，新增时为1，更新时需带上读取到的版本号，版本号不一致时不会更新任何数据
+// CreatorId/UpdaterId 由 server.Crud 根据登录用户填充
+type BaseModel struct {
+	UpdateTime DateTime `json:"updateTime,omitempty" xorm:"updated"`
+	CreatorId  string   `json:"creatorId,omitempty" xorm:"varchar(50) comment('创建人ID')"`
+	UpdaterId  string   `json:"updaterId,omitempty" xorm:"varchar(50) comment('更新人ID')"`
+	DeleteTime DateTime `json:"-" xorm:"deleted"`
+	Version    int      `json:"version,omitempty" xorm:"version" validate:"required@update"`
+}
+
+// Auditable 可记录创建人/更新人的模型，嵌入 BaseModel 即实现
+type Auditable interface {
+	SetCreatorId(id string)
+	SetUpdaterId(id string)
+}
+
+// PasswordHashable 含密码字段的模型，server.Crud 新增/修改前调用 HashPassword 将明文密码散列
+type PasswordHashable interface {
+	HashPassword() error
+}
+
+// Sanitizable 含敏感字段的模型，server.Crud 返回前调用 Sanitize 清除敏感字段
+type Sanitizable interface {
+	Sanitize()
+}
+
+func (m *BaseModel) SetCreatorId(id string) {
+	m.CreatorId = id
+}
+
+func (m *BaseModel) SetUpdaterId(id string) {
+	m.UpdaterId = id
+}
+
+// TenantModel 租户数据表字段，以 xorm:"extends" 嵌入后即为 database.TenantScoped，
+// 通过 database.Scoped 及 server.Crud 访问时自动按租户隔离
+type TenantModel struct {
+	TenantId string `json:"tenantId,omitempty" xorm:"index varchar(50) comment('租户ID')"`
+}
+
+func (m *TenantModel) GetTenantId() string {
+	return m.TenantId
+}
+
+func (m *TenantModel) SetTenantId(tenantId string) {
+	m.TenantId = tenantId
+}
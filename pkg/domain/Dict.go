@@ -5,12 +5,13 @@ const (
 )
 
 type Dict struct {
-	Id         string   `json:"id,omitempty" xorm:"pk varchar(50)"`
-	DictKey    string   `json:"dictKey,omitempty" xorm:"index varchar(50) comment('字典键')"`
+	Id         string   `json:"id,omitempty" xorm:"pk varchar(50)" validate:"required@update,max=50"`
+	DictKey    string   `json:"dictKey,omitempty" xorm:"index varchar(50) comment('字典键')" validate:"required@add,max=50" filter:"eq,like,in,sort"`
 	DictValue  string   `json:"dictValue,omitempty" xorm:"comment('字典值')"`
 	DictExt    string   `json:"dictExt,omitempty" xorm:"comment('字典扩展值')"`
-	ParentId   string   `json:"parentId,omitempty" xorm:"comment('父ID，若无则为字典分类')"`
-	CreateTime DateTime `json:"createTime,omitempty" xorm:"created"`
+	ParentId   string   `json:"parentId,omitempty" xorm:"comment('父ID，若无则为字典分类')" validate:"max=50" filter:"eq,in"`
+	CreateTime DateTime `json:"createTime,omitempty" xorm:"created" filter:"gte,lte,sort"`
+	BaseModel  `xorm:"extends"`
 }
 
 func init() {
@@ -0,0 +1,91 @@
+package domain
+
+import (
+	"reflect"
+
+	"github.com/yockii/qscore/pkg/util"
+)
+
+// EncryptedString 加密存储的字符串字段，实现xorm的Conversion接口
+// 写入时使用 util 默认AEAD密钥环(配置项 crypto.aead)加密，读取时自动解密，旧版本密钥加密的数据同样可以解密
+// 注意xorm更新时总会包含该类型字段，部分更新请配合Cols/Omit使用
+//
+//	type Customer struct {
+//		Id         string                 `xorm:"pk varchar(50)"`
+//		Phone      domain.EncryptedString `xorm:"varchar(200)"`
+//		PhoneIndex string                 `json:"-" xorm:"index varchar(64)" blindIndex:"Phone"`
+//	}
+//	func (c *Customer) BeforeInsert() { _ = domain.FillBlindIndexes(c) }
+//	func (c *Customer) BeforeUpdate() { _ = domain.FillBlindIndexes(c) }
+//
+// 按手机号查询: idx, _ := domain.BlindIndex(phone); db.Where("phone_index = ?", idx).Get(&c)
+type EncryptedString string
+
+func (s EncryptedString) ToDB() ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	rst, err := util.AeadEncryptBase64String(string(s))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(rst), nil
+}
+
+func (s *EncryptedString) FromDB(data []byte) error {
+	if len(data) == 0 {
+		*s = ""
+		return nil
+	}
+	rst, err := util.AeadDecryptBase64String(string(data))
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(rst)
+	return nil
+}
+
+// BlindIndex 计算等值查询用的盲索引
+func BlindIndex(value string) (string, error) {
+	return util.BlindIndex(value)
+}
+
+// FillBlindIndexes 根据 blindIndex:"源字段名" 标签为索引字段填充源字段的盲索引，源字段为空时索引置空
+func FillBlindIndexes(bean interface{}) error {
+	v := reflect.ValueOf(bean)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	return fillBlindIndexes(v.Elem())
+}
+
+func fillBlindIndexes(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := fillBlindIndexes(v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		source, ok := field.Tag.Lookup("blindIndex")
+		if !ok || field.Type.Kind() != reflect.String {
+			continue
+		}
+		sv := v.FieldByName(source)
+		if !sv.IsValid() || sv.Kind() != reflect.String {
+			continue
+		}
+		if sv.String() == "" {
+			v.Field(i).SetString("")
+			continue
+		}
+		idx, err := BlindIndex(sv.String())
+		if err != nil {
+			return err
+		}
+		v.Field(i).SetString(idx)
+	}
+	return nil
+}
@@ -49,7 +49,7 @@ var DateTimeConverter = func(value string) reflect.Value {
 
 type TimeCondition struct {
 	Start DateTime `json:"start,omitempty" query:"start"`
-	End   DateTime `json:"end,omitempty" query:"end"`
+	End   DateTime `json:"end,omitempty" query:"end" validate:"gtefield=Start"`
 }
 
 type CommonResponse struct {
@@ -64,3 +64,12 @@ type Paginate struct {
 	Limit  int         `json:"limit"`
 	Items  interface{} `json:"items"`
 }
+
+// CursorPaginate 游标分页结果，Next/Prev为空表示没有下一页/上一页，未要求统计时Total为空
+type CursorPaginate struct {
+	Total *int        `json:"total,omitempty"`
+	Limit int         `json:"limit"`
+	Next  string      `json:"next,omitempty"`
+	Prev  string      `json:"prev,omitempty"`
+	Items interface{} `json:"items"`
+}
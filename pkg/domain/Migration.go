@@ -0,0 +1,164 @@
+package domain
+
+import (
+	"context"
+
+	"xorm.io/xorm"
+	"xorm.io/xorm/schemas"
+
+	"github.com/yockii/qscore/pkg/database"
+)
+
+// 内置领域模型的数据库迁移，业务模型请自行注册迁移，SyncDomains 仅保留用于兼容
+func init() {
+	database.RegisterMigration(&database.Migration{
+		Version:     2021120101,
+		Description: "create domain tables",
+		Up:          createDomainTables,
+		Down: func(session *xorm.Session) error {
+			for _, name := range []string{"Dict", "Resource", "Role", "User"} {
+				if err := session.DropTable(domainTable(session, name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	database.RegisterMigration(&database.Migration{
+		Version:     2021120103,
+		Description: "add audit, soft delete and version columns",
+		// 表已存在时Sync2会在事务外读取表结构，因此逐列检查并添加，已有数据的版本号置为1
+		Up: func(session *xorm.Session) error {
+			engine := session.Engine()
+			ctx := context.Background()
+			// 迁移时 BaseModel 的列定义快照
+			type auditColumns struct {
+				UpdateTime DateTime `xorm:"updated"`
+				CreatorId  string   `xorm:"varchar(50)"`
+				UpdaterId  string   `xorm:"varchar(50)"`
+				DeleteTime DateTime `xorm:"deleted"`
+				Version    int      `xorm:"version"`
+			}
+			columns, err := engine.TableInfo(new(auditColumns))
+			if err != nil {
+				return err
+			}
+			for _, name := range domainTables {
+				table := domainTable(session, name)
+				for _, column := range baseModelColumns {
+					exist, err := engine.Dialect().IsColumnExist(session.Tx(), ctx, table, column)
+					if err != nil {
+						return err
+					}
+					if exist {
+						continue
+					}
+					if _, err = session.Exec(engine.Dialect().AddColumnSQL(table, columns.GetColumn(column))); err != nil {
+						return err
+					}
+				}
+				if _, err = session.Exec("UPDATE " + engine.Quote(table) + " SET " + engine.Quote("version") + " = 1 WHERE " + engine.Quote("version") + " IS NULL"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(session *xorm.Session) error {
+			for _, name := range domainTables {
+				table := session.Engine().Quote(domainTable(session, name))
+				for _, column := range baseModelColumns {
+					if _, err := session.Exec("ALTER TABLE " + table + " DROP COLUMN " + session.Engine().Quote(column)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	})
+	database.RegisterMigration(&database.Migration{
+		Version:     2021120104,
+		Description: "unique username",
+		// 用户名唯一(含已软删除的用户)，避免多实例同时初始化管理员时重复创建，已有重复用户名时需先清理
+		Up: func(session *xorm.Session) error {
+			return replaceUsernameIndex(session, schemas.IndexType, schemas.UniqueType)
+		},
+		Down: func(session *xorm.Session) error {
+			return replaceUsernameIndex(session, schemas.UniqueType, schemas.IndexType)
+		},
+	})
+}
+
+func replaceUsernameIndex(session *xorm.Session, from, to int) error {
+	dialect := session.Engine().Dialect()
+	table := domainTable(session, "User")
+	indexes, err := dialect.GetIndexes(session.Tx(), context.Background(), table)
+	if err != nil {
+		return err
+	}
+	// 表可能由 Sync2(SyncDomains...) 按当前的结构体创建，已有目标索引时不再处理
+	var replaced *schemas.Index
+	for _, index := range indexes {
+		if len(index.Cols) != 1 || index.Cols[0] != "username" {
+			continue
+		}
+		if index.Type == to {
+			return nil
+		}
+		if index.Type == from {
+			replaced = index
+		}
+	}
+	if replaced != nil {
+		if _, err = session.Exec(dialect.DropIndexSQL(table, replaced)); err != nil {
+			return err
+		}
+	}
+	index := schemas.NewIndex("username", to)
+	index.AddColumn("username")
+	_, err = session.Exec(dialect.CreateIndexSQL(table, index))
+	return err
+}
+
+// baseModelColumns BaseModel 对应的列
+var baseModelColumns = []string{"update_time", "creator_id", "updater_id", "delete_time", "version"}
+
+// domainTables 内置领域模型的结构体名，表名由结构体名经 TableMapper(含表前缀)映射
+var domainTables = []string{"User", "Role", "Resource", "Dict"}
+
+func domainTable(session *xorm.Session, name string) string {
+	return session.Engine().GetTableMapper().Obj2Table(name)
+}
+
+// createDomainTables 按创建时的表结构建表，与领域模型的后续变更无关，表结构变更请新增迁移
+// 结构体名决定表名，因此在函数内声明同名的快照类型
+func createDomainTables(session *xorm.Session) error {
+	type User struct {
+		Id         string   `xorm:"pk varchar(50)"`
+		Username   string   `xorm:"index varchar(50) comment('用户名')"`
+		Password   string   `xorm:"comment('密码')"`
+		CreateTime DateTime `xorm:"created"`
+	}
+	type Role struct {
+		Id         string `xorm:"pk varchar(50)"`
+		RoleName   string `xorm:"varchar(50)"`
+		RoleDesc   string
+		CreateTime DateTime `xorm:"created"`
+	}
+	type Resource struct {
+		Id              string   `xorm:"pk varchar(50)"`
+		ResourceName    string   `xorm:"comment('资源名称')"`
+		ResourceContent string   `xorm:"comment('资源内容，如url、数据分类等等')"`
+		ResourceType    string   `xorm:"comment('资源类型，定义：route、data')"`
+		Action          string   `xorm:"comment('资源操作类型，如url有GET/POST/PUT/DELETE')"`
+		CreateTime      DateTime `xorm:"created"`
+	}
+	type Dict struct {
+		Id         string   `xorm:"pk varchar(50)"`
+		DictKey    string   `xorm:"index varchar(50) comment('字典键')"`
+		DictValue  string   `xorm:"comment('字典值')"`
+		DictExt    string   `xorm:"comment('字典扩展值')"`
+		ParentId   string   `xorm:"comment('父ID，若无则为字典分类')"`
+		CreateTime DateTime `xorm:"created"`
+	}
+	return session.Sync2(new(User), new(Role), new(Resource), new(Dict))
+}
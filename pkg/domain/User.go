@@ -1,5 +1,7 @@
 package domain
 
+import "github.com/yockii/qscore/pkg/password"
+
 const (
 	UserIdPrefix     = "user"
 	RoleIdPrefix     = "role"
@@ -7,32 +9,70 @@ const (
 )
 
 type User struct {
-	Id         string   `json:"id,omitempty" xorm:"pk varchar(50)"`
-	Username   string   `json:"username,omitempty" xorm:"index varchar(50) comment('用户名')"`
-	Password   string   `json:"password,omitempty" xorm:"comment('密码')"`
-	CreateTime DateTime `json:"createTime,omitempty" xorm:"created"`
+	Id         string   `json:"id,omitempty" xorm:"pk varchar(50)" validate:"required@update,max=50"`
+	Username   string   `json:"username,omitempty" xorm:"unique varchar(50) comment('用户名')" validate:"required@add,max=50" filter:"eq,like,sort"`
+	Password   string   `json:"password,omitempty" xorm:"comment('密码')" validate:"required@add,max=64"`
+	CreateTime DateTime `json:"createTime,omitempty" xorm:"created" filter:"gte,lte,sort"`
+	BaseModel  `xorm:"extends"`
 }
 
 type Role struct {
-	Id         string   `json:"id,omitempty" xorm:"pk varchar(50)"`
-	RoleName   string   `json:"roleName,omitempty" xorm:"varchar(50)"`
+	Id         string   `json:"id,omitempty" xorm:"pk varchar(50)" validate:"required@update,max=50"`
+	RoleName   string   `json:"roleName,omitempty" xorm:"varchar(50)" validate:"required@add,max=50" filter:"eq,like,sort"`
 	RoleDesc   string   `json:"roleDesc,omitempty"`
-	CreateTime DateTime `json:"createTime,omitempty" xorm:"created"`
+	CreateTime DateTime `json:"createTime,omitempty" xorm:"created" filter:"gte,lte,sort"`
+	BaseModel  `xorm:"extends"`
 }
 
 type Resource struct {
-	Id              string   `json:"id,omitempty" xorm:"pk varchar(50)"`
-	ResourceName    string   `json:"resourceName,omitempty" xorm:"comment('资源名称')"`
-	ResourceContent string   `json:"resourceContent,omitempty" xorm:"comment('资源内容，如url、数据分类等等')"`
-	ResourceType    string   `json:"resourceType,omitempty" xorm:"comment('资源类型，定义：route、data')"`
-	Action          string   `json:"action,omitempty" xorm:"comment('资源操作类型，如url有GET/POST/PUT/DELETE')"`
-	CreateTime      DateTime `json:"createTime,omitempty" xorm:"created"`
+	Id              string   `json:"id,omitempty" xorm:"pk varchar(50)" validate:"required@update,max=50"`
+	ResourceName    string   `json:"resourceName,omitempty" xorm:"comment('资源名称')" validate:"required@add" filter:"eq,like,sort"`
+	ResourceContent string   `json:"resourceContent,omitempty" xorm:"comment('资源内容，如url、数据分类等等')" validate:"required@add" filter:"eq,like"`
+	ResourceType    string   `json:"resourceType,omitempty" xorm:"comment('资源类型，定义：route、data')" validate:"required@add,enum=route|data" filter:"eq,in"`
+	Action          string   `json:"action,omitempty" xorm:"comment('资源操作类型，如url有GET/POST/PUT/DELETE')" filter:"eq,in"`
+	CreateTime      DateTime `json:"createTime,omitempty" xorm:"created" filter:"gte,lte,sort"`
+	BaseModel       `xorm:"extends"`
 }
 
 func init() {
 	SyncDomains = append(SyncDomains, User{}, Role{}, Resource{})
 }
 
+// SetPassword 使用默认算法散列并设置密码
+func (u *User) SetPassword(plain string) error {
+	encoded, err := password.Hash(plain)
+	if err != nil {
+		return err
+	}
+	u.Password = encoded
+	return nil
+}
+
+// CheckPassword 校验密码，rehashed为true时表示已使用当前算法及参数重新散列，调用方需保存Password字段
+func (u *User) CheckPassword(plain string) (ok bool, rehashed bool, err error) {
+	ok, needsRehash, err := password.Verify(plain, u.Password)
+	if err != nil || !ok || !needsRehash {
+		return
+	}
+	if err = u.SetPassword(plain); err != nil {
+		return true, false, err
+	}
+	return true, true, nil
+}
+
+// HashPassword 散列请求中的明文密码，密码为空(修改时不修改密码)时不处理
+func (u *User) HashPassword() error {
+	if u.Password == "" {
+		return nil
+	}
+	return u.SetPassword(u.Password)
+}
+
+// Sanitize 清除密码散列，避免返回给客户端
+func (u *User) Sanitize() {
+	u.Password = ""
+}
+
 type UserRequest struct {
 	User
 	CreateTimeRange *TimeCondition `json:"createTimeRange,omitempty"`
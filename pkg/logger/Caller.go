@@ -35,6 +35,10 @@ func (hook *GetCallerHook) Fire(entry *logrus.Entry) error {
 	//	strings.Join(LogsConfig.LogLevelReportCaller, ",") == "" ||
 	//	strings.Contains(strings.ToLower(strings.Join(LogsConfig.LogLevelReportCaller, ",")), entry.Level.String()) {
 	entry.Caller = hook.getCaller()
+	// 调用栈全部位于跳过的包中时(如本项目启动的goroutine)找不到调用方
+	if entry.Caller == nil {
+		return nil
+	}
 	fileVal := fmt.Sprintf("%s:%d", entry.Caller.File, entry.Caller.Line)
 	entry.Data[hook.Field] = fileVal
 	//}
@@ -245,3 +245,10 @@ func Traceln(args ...interface{}) {
 func Tracef(format string, args ...interface{}) {
 	DefaultLogger.Tracef(format, args...)
 }
+
+type Fields = logrus.Fields
+
+// WithFields 输出带字段的结构化日志
+func WithFields(fields Fields) *logrus.Entry {
+	return DefaultLogger.WithFields(fields)
+}
@@ -0,0 +1,113 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/yockii/qscore/pkg/config"
+)
+
+const (
+	argon2idId           = "argon2id"
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Time    = 3
+	defaultArgon2Threads = 2
+	argon2SaltLength     = 16
+	argon2KeyLength      = 32
+
+	// 散列值中参数的上限，避免异常的散列值耗尽内存或CPU
+	maxArgon2Memory = 4 * 1024 * 1024
+	maxArgon2Time   = 64
+)
+
+type argon2idHasher struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// NewArgon2id 参数为0时读取配置项 password.argon2.memory(KiB) / password.argon2.time / password.argon2.threads
+func NewArgon2id(memory, time uint32, threads uint8) Hasher {
+	return &argon2idHasher{memory: memory, time: time, threads: threads}
+}
+
+func (h *argon2idHasher) Id() string {
+	return argon2idId
+}
+
+func (h *argon2idHasher) params() (memory, time uint32, threads uint8) {
+	memory, time, threads = h.memory, h.time, h.threads
+	if memory == 0 {
+		if memory = uint32(config.GetUint("password.argon2.memory")); memory == 0 {
+			memory = defaultArgon2Memory
+		}
+	}
+	if time == 0 {
+		if time = uint32(config.GetUint("password.argon2.time")); time == 0 {
+			time = defaultArgon2Time
+		}
+	}
+	if threads == 0 {
+		if threads = uint8(config.GetUint("password.argon2.threads")); threads == 0 {
+			threads = defaultArgon2Threads
+		}
+	}
+	return
+}
+
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	memory, time, threads := h.params()
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, time, memory, threads, argon2KeyLength)
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", memory, time, threads)
+	return fmt.Sprintf("$%s$v=%d$%s$%s$%s", argon2idId, argon2.Version, params,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+// decode $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+func (h *argon2idHasher) decode(encoded string) (params map[string]int, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != argon2idId {
+		return nil, nil, nil, ErrInvalidHash
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrInvalidHash
+	}
+	if params, err = parsePhcParams(parts[3]); err != nil {
+		return
+	}
+	m, t, p := params["m"], params["t"], params["p"]
+	if p <= 0 || p > 255 || t <= 0 || t > maxArgon2Time || m < 8*p || m > maxArgon2Memory {
+		return nil, nil, nil, ErrInvalidHash
+	}
+	if salt, key, err = decodeSaltKey(parts[4], parts[5]); err != nil {
+		return nil, nil, nil, err
+	}
+	return
+}
+
+func (h *argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, uint32(params["t"]), uint32(params["m"]), uint8(params["p"]), uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := h.decode(encoded)
+	if err != nil {
+		return true
+	}
+	memory, time, threads := h.params()
+	return uint32(params["m"]) < memory || uint32(params["t"]) < time || uint8(params["p"]) < threads || len(key) < argon2KeyLength
+}
@@ -0,0 +1,53 @@
+package password
+
+import (
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/yockii/qscore/pkg/config"
+)
+
+const bcryptId = "bcrypt"
+
+type bcryptHasher struct {
+	cost int
+}
+
+// NewBcrypt cost为0时读取配置项 password.bcrypt.cost，默认 bcrypt.DefaultCost
+func NewBcrypt(cost int) Hasher {
+	return &bcryptHasher{cost: cost}
+}
+
+func (h *bcryptHasher) Id() string {
+	return bcryptId
+}
+
+func (h *bcryptHasher) currentCost() int {
+	if h.cost > 0 {
+		return h.cost
+	}
+	if c := config.GetInt("password.bcrypt.cost"); c >= bcrypt.MinCost {
+		return c
+	}
+	return bcrypt.DefaultCost
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	bs, err := bcrypt.GenerateFromPassword([]byte(password), h.currentCost())
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+func (h *bcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.currentCost()
+}
@@ -0,0 +1,173 @@
+package password
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yockii/qscore/pkg/config"
+	"github.com/yockii/qscore/pkg/util"
+)
+
+// Hasher 密码散列算法，散列结果采用PHC字符串格式，$<id>$<参数>$<盐>$<散列值>，算法及参数随散列值一起保存
+type Hasher interface {
+	// Id PHC格式中的算法标识
+	Id() string
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash 散列值使用的参数弱于当前配置时返回true
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	ErrUnknownAlgorithm = errors.New("未知的密码散列算法")
+	ErrInvalidHash      = errors.New("非法的密码散列值")
+)
+
+var (
+	lock           sync.RWMutex
+	hashers        = make(map[string]Hasher)
+	defaultHasher  string
+	phcEncoding    = base64.RawStdEncoding
+	legacyMd5Check bool
+)
+
+func init() {
+	Register(NewBcrypt(0))
+	Register(NewArgon2id(0, 0, 0))
+	Register(NewSm3(0))
+}
+
+// Register 注册散列算法，相同Id的算法会被覆盖
+func Register(h Hasher) {
+	lock.Lock()
+	defer lock.Unlock()
+	hashers[h.Id()] = h
+}
+
+// SetDefault 设置新密码使用的算法，未设置时读取配置项 password.algorithm，默认argon2id
+func SetDefault(id string) error {
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := hashers[id]; !ok {
+		return ErrUnknownAlgorithm
+	}
+	defaultHasher = id
+	return nil
+}
+
+// SetLegacyMd5 是否兼容旧的无盐md5密码，默认不兼容，仅在迁移旧系统的密码时开启，兼容时md5密码校验通过后会被要求重新散列
+func SetLegacyMd5(enable bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	legacyMd5Check = enable
+}
+
+func legacyMd5Enabled() bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return legacyMd5Check
+}
+
+func Default() Hasher {
+	lock.RLock()
+	defer lock.RUnlock()
+	id := defaultHasher
+	if id == "" {
+		id = config.GetString("password.algorithm")
+	}
+	if h, ok := hashers[id]; ok {
+		return h
+	}
+	return hashers[argon2idId]
+}
+
+func Get(id string) (Hasher, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	h, ok := hashers[id]
+	return h, ok
+}
+
+// Hash 使用默认算法散列密码
+func Hash(password string) (string, error) {
+	return Default().Hash(password)
+}
+
+// Verify 校验密码，needsRehash为true时调用方应使用 Hash 重新散列并保存
+func Verify(password, encoded string) (ok bool, needsRehash bool, err error) {
+	if !strings.HasPrefix(encoded, "$") {
+		if len(encoded) == 32 && legacyMd5Enabled() {
+			ok = subtle.ConstantTimeCompare([]byte(encoded), []byte(util.Md5(password))) == 1
+			return ok, ok, nil
+		}
+		return false, false, ErrInvalidHash
+	}
+	h, found := Get(hashId(encoded))
+	if !found {
+		return false, false, ErrUnknownAlgorithm
+	}
+	ok, err = h.Verify(password, encoded)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	d := Default()
+	needsRehash = d.Id() != h.Id() || h.NeedsRehash(encoded)
+	return
+}
+
+// hashId 获取PHC字符串中的算法标识，bcrypt的 2a/2b/2y 统一为bcrypt
+func hashId(encoded string) string {
+	parts := strings.SplitN(encoded, "$", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	switch parts[1] {
+	case "2a", "2b", "2y":
+		return bcryptId
+	}
+	return parts[1]
+}
+
+// parsePhcParams 解析 k=v,k=v 形式的参数
+func parsePhcParams(s string) (map[string]int, error) {
+	params := make(map[string]int)
+	for _, kv := range strings.Split(s, ",") {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 {
+			return nil, ErrInvalidHash
+		}
+		v, err := strconv.Atoi(pair[1])
+		if err != nil {
+			return nil, ErrInvalidHash
+		}
+		params[pair[0]] = v
+	}
+	return params, nil
+}
+
+// 散列值中盐及摘要的长度范围，摘要为空时任意密码都能通过校验
+const (
+	minSaltLength = 8
+	minKeyLength  = 16
+	maxKeyLength  = 128
+)
+
+// decodeSaltKey 解码盐及摘要并校验长度
+func decodeSaltKey(encodedSalt, encodedKey string) (salt, key []byte, err error) {
+	if salt, err = phcEncoding.DecodeString(encodedSalt); err != nil || len(salt) < minSaltLength {
+		return nil, nil, ErrInvalidHash
+	}
+	if key, err = phcEncoding.DecodeString(encodedKey); err != nil || len(key) < minKeyLength || len(key) > maxKeyLength {
+		return nil, nil, ErrInvalidHash
+	}
+	return
+}
+
+func formatPhc(id, params string, salt, hash []byte) string {
+	return fmt.Sprintf("$%s$%s$%s$%s", id, params, phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(hash))
+}
@@ -0,0 +1,89 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/tjfoc/gmsm/sm3"
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/yockii/qscore/pkg/config"
+)
+
+const (
+	sm3Id                = "pbkdf2-sm3"
+	defaultSm3Iterations = 100000
+	sm3SaltLength        = 16
+	sm3KeyLength         = 32
+	// maxSm3Iterations 散列值中迭代次数的上限，避免异常的散列值耗尽CPU
+	maxSm3Iterations = 10000000
+)
+
+// sm3Hasher 加盐的国密SM3散列，使用PBKDF2(HMAC-SM3)迭代
+type sm3Hasher struct {
+	iterations int
+}
+
+// NewSm3 iterations为0时读取配置项 password.sm3.iterations，默认100000
+func NewSm3(iterations int) Hasher {
+	return &sm3Hasher{iterations: iterations}
+}
+
+func (h *sm3Hasher) Id() string {
+	return sm3Id
+}
+
+func (h *sm3Hasher) currentIterations() int {
+	if h.iterations > 0 {
+		return h.iterations
+	}
+	if i := config.GetInt("password.sm3.iterations"); i > 0 {
+		return i
+	}
+	return defaultSm3Iterations
+}
+
+func (h *sm3Hasher) Hash(password string) (string, error) {
+	iterations := h.currentIterations()
+	salt := make([]byte, sm3SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, iterations, sm3KeyLength, sm3.New)
+	return formatPhc(sm3Id, fmt.Sprintf("i=%d", iterations), salt, key), nil
+}
+
+// decode $pbkdf2-sm3$i=100000$salt$hash
+func (h *sm3Hasher) decode(encoded string) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != sm3Id {
+		return 0, nil, nil, ErrInvalidHash
+	}
+	params, err := parsePhcParams(parts[2])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if iterations = params["i"]; iterations <= 0 || iterations > maxSm3Iterations {
+		return 0, nil, nil, ErrInvalidHash
+	}
+	if salt, key, err = decodeSaltKey(parts[3], parts[4]); err != nil {
+		return 0, nil, nil, err
+	}
+	return
+}
+
+func (h *sm3Hasher) Verify(password, encoded string) (bool, error) {
+	iterations, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := pbkdf2.Key([]byte(password), salt, iterations, len(key), sm3.New)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *sm3Hasher) NeedsRehash(encoded string) bool {
+	iterations, _, key, err := h.decode(encoded)
+	return err != nil || iterations < h.currentIterations() || len(key) < sm3KeyLength
+}
@@ -0,0 +1,221 @@
+package seed
+
+import (
+	"crypto/rand"
+	"math/big"
+
+	"github.com/spf13/viper"
+	"xorm.io/xorm"
+
+	"github.com/yockii/qscore/pkg/authorization"
+	"github.com/yockii/qscore/pkg/config"
+	"github.com/yockii/qscore/pkg/constant"
+	"github.com/yockii/qscore/pkg/database"
+	"github.com/yockii/qscore/pkg/domain"
+	"github.com/yockii/qscore/pkg/logger"
+	"github.com/yockii/qscore/pkg/util"
+)
+
+// 初始化数据，可重复执行，已存在的数据不会被修改
+// 需在数据库迁移(database.MigrateUp 或配置 database.autoMigrate) 及 authorization.Init 之后调用
+
+// File 种子数据文件，支持viper可读取的格式，如yaml：
+//
+//	resources:
+//	  - resourceName: 用户管理
+//	    resourceContent: /api/v1/user/*
+//	    resourceType: route
+//	    action: GET
+//	dicts:
+//	  - dictKey: gender
+//	    dictValue: 性别
+//	    children:
+//	      - dictKey: male
+//	        dictValue: 男
+type File struct {
+	Resources []*domain.Resource
+	Dicts     []*Dict
+}
+
+type Dict struct {
+	DictKey   string
+	DictValue string
+	DictExt   string
+	Children  []*Dict
+}
+
+const passwordChars = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz23456789!@#$%^&*"
+
+// Run 初始化管理员，并加载配置项 seed.file 指定的种子数据文件
+func Run() error {
+	if err := SeedAdmin(); err != nil {
+		return err
+	}
+	if file := config.GetString("seed.file"); file != "" {
+		return LoadFile(file)
+	}
+	return nil
+}
+
+// SeedAdmin 创建默认管理员用户及超级管理员角色，并为管理员赋予超级管理员角色
+// 管理员不存在时生成随机密码，仅在创建时输出到日志一次，请登录后立即修改
+// 多实例同时执行时依靠用户名唯一索引及角色的固定ID避免重复创建，插入失败时重新读取
+// 管理员已被软删除时视为有意停用，不会重新创建或授权
+func SeedAdmin() error {
+	roleName := authorization.SuperAdmin()
+	if err := seedRole(roleName); err != nil {
+		return err
+	}
+
+	user := new(domain.User)
+	has, err := database.DB.Unscoped().Where("username = ?", constant.DefaultUsername).Get(user)
+	if err != nil {
+		return err
+	}
+	if !has {
+		if user, err = createAdmin(); err != nil {
+			return err
+		}
+	}
+	if !user.DeleteTime.IsZero() {
+		logger.Warnf("管理员 %s 已被删除，不再重新创建及授权", constant.DefaultUsername)
+		return nil
+	}
+
+	// 超级管理员校验使用空域
+	if _, err = authorization.AddSubjectGroup(user.Id, roleName, ""); err != nil {
+		return err
+	}
+	return nil
+}
+
+func seedRole(roleName string) error {
+	has, err := database.DB.Unscoped().Where("role_name = ?", roleName).Exist(new(domain.Role))
+	if err != nil || has {
+		return err
+	}
+	// 固定ID，并发插入时仅一个成功
+	role := &domain.Role{Id: domain.RoleIdPrefix + roleName, RoleName: roleName}
+	if _, err = database.DB.Insert(role); err != nil {
+		if has, _ = database.DB.Unscoped().ID(role.Id).Exist(new(domain.Role)); has {
+			return nil
+		}
+		return err
+	}
+	logger.Infof("已创建角色 %s", roleName)
+	return nil
+}
+
+func createAdmin() (*domain.User, error) {
+	pwd, err := generatePassword(16)
+	if err != nil {
+		return nil, err
+	}
+	user := &domain.User{Id: domain.UserIdPrefix + util.GenerateDatabaseID(), Username: constant.DefaultUsername}
+	if err = user.SetPassword(pwd); err != nil {
+		return nil, err
+	}
+	if _, err = database.DB.Insert(user); err != nil {
+		// 其他实例可能已创建
+		existing := new(domain.User)
+		if has, _ := database.DB.Unscoped().Where("username = ?", constant.DefaultUsername).Get(existing); has {
+			return existing, nil
+		}
+		return nil, err
+	}
+	logger.Warnf("已创建管理员 %s，初始密码: %s ，该密码仅显示一次，请登录后立即修改", user.Username, pwd)
+	return user, nil
+}
+
+func generatePassword(length int) (string, error) {
+	bs := make([]byte, length)
+	max := big.NewInt(int64(len(passwordChars)))
+	for i := range bs {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		bs[i] = passwordChars[n.Int64()]
+	}
+	return string(bs), nil
+}
+
+// LoadFile 读取种子数据文件并导入
+func LoadFile(path string) error {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	f := new(File)
+	if err := v.Unmarshal(f); err != nil {
+		return err
+	}
+	return Load(f)
+}
+
+// Load 在事务中导入资源及字典
+// 资源按 资源内容+资源类型+操作 判断是否存在，字典按 字典键+父ID 判断是否存在，已软删除的视为存在，不会重新导入
+func Load(f *File) error {
+	session := database.DB.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return err
+	}
+	if err := loadFile(session, f); err != nil {
+		_ = session.Rollback()
+		return err
+	}
+	return session.Commit()
+}
+
+func loadFile(session *xorm.Session, f *File) error {
+	for _, r := range f.Resources {
+		// 包含已软删除的资源，管理员删除后不再重新导入
+		has, err := session.Unscoped().Where("resource_content = ? AND resource_type = ? AND action = ?",
+			r.ResourceContent, r.ResourceType, r.Action).Exist(new(domain.Resource))
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if r.Id == "" {
+			r.Id = domain.ResourceIdPrefix + util.GenerateDatabaseID()
+		}
+		if _, err = session.Insert(r); err != nil {
+			return err
+		}
+	}
+	return loadDicts(session, "", f.Dicts)
+}
+
+func loadDicts(session *xorm.Session, parentId string, dicts []*Dict) error {
+	for _, d := range dicts {
+		dict := new(domain.Dict)
+		// 空字符串字段不会作为条件，需显式指定；包含已软删除的字典，管理员删除后不再重新导入，其子项同样跳过
+		has, err := session.Unscoped().Where("dict_key = ? AND parent_id = ?", d.DictKey, parentId).Get(dict)
+		if err != nil {
+			return err
+		}
+		if has && !dict.DeleteTime.IsZero() {
+			continue
+		}
+		if !has {
+			dict = &domain.Dict{
+				Id:        domain.DictIdPrefix + util.GenerateDatabaseID(),
+				DictKey:   d.DictKey,
+				DictValue: d.DictValue,
+				DictExt:   d.DictExt,
+				ParentId:  parentId,
+			}
+			if _, err = session.Insert(dict); err != nil {
+				return err
+			}
+		}
+		if err = loadDicts(session, dict.Id, d.Children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/yockii/qscore/pkg/apperr"
+	"github.com/yockii/qscore/pkg/authorization"
+	"github.com/yockii/qscore/pkg/config"
+	"github.com/yockii/qscore/pkg/database"
+	"github.com/yockii/qscore/pkg/domain"
+	"github.com/yockii/qscore/pkg/logger"
+	"github.com/yockii/qscore/pkg/session"
+	"github.com/yockii/qscore/pkg/util"
+)
+
+const (
+	TokenCookieName           = "token"
+	RefreshTokenCookieName    = "refreshToken"
+	tokenTypeAccess           = "access"
+	tokenTypeRefresh          = "refresh"
+	defaultTokenExpire        = 30 * time.Minute
+	defaultRefreshTokenExpire = 7 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidCredentials  = apperr.ErrUnauthorized.WithMsg("auth.invalidCredentials")
+	ErrInvalidRefreshToken = apperr.ErrUnauthorized.WithMsg("auth.invalidRefreshToken")
+	ErrRefreshTokenReused  = apperr.ErrUnauthorized.WithMsg("auth.refreshTokenReused")
+	ErrTenantDenied        = apperr.ErrReject.WithMsg("auth.tenantDenied")
+	ErrRefreshUnavailable  = apperr.ErrUnauthorized.WithMsg("auth.refreshUnavailable")
+)
+
+func init() {
+	apperr.RegisterMessages(apperr.LangZh, map[string]string{
+		"auth.invalidCredentials":  "用户名或密码错误",
+		"auth.invalidRefreshToken": "refresh token无效或已过期",
+		"auth.refreshTokenReused":  "refresh token被重复使用，会话已注销",
+		"auth.lackOfCredentials":   "用户名和密码不能为空",
+		"auth.lackOfRefreshToken":  "refresh token不能为空",
+		"auth.invalidToken":        "无效的token信息",
+		"auth.expiredToken":        "token信息失效",
+		"auth.tenantDenied":        "用户不属于该租户",
+		"auth.refreshUnavailable":  "未启用redis，无法刷新token，请重新登录",
+	})
+	apperr.RegisterMessages(apperr.LangEn, map[string]string{
+		"auth.invalidCredentials":  "Invalid username or password",
+		"auth.invalidRefreshToken": "Invalid or expired refresh token",
+		"auth.refreshTokenReused":  "Refresh token reused, session revoked",
+		"auth.lackOfCredentials":   "Username and password are required",
+		"auth.lackOfRefreshToken":  "Refresh token is required",
+		"auth.invalidToken":        "Missing or malformed token",
+		"auth.expiredToken":        "Invalid or expired token",
+		"auth.tenantDenied":        "User does not belong to the tenant",
+		"auth.refreshUnavailable":  "Token refresh requires redis, please log in again",
+	})
+}
+
+type LoginRequest struct {
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+	TenantId string `json:"tenantId,omitempty" form:"tenantId"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" form:"refreshToken"`
+}
+
+type TokenPair struct {
+	Token           string `json:"token"`
+	ExpireIn        int64  `json:"expireIn"`
+	RefreshToken    string `json:"refreshToken"`
+	RefreshExpireIn int64  `json:"refreshExpireIn"`
+}
+
+type LoginResponse struct {
+	*TokenPair
+	User *domain.User `json:"user"`
+}
+
+// TokenExpire 访问token的有效期，配置项 jwt.expire，默认30分钟
+func TokenExpire() time.Duration {
+	if d := config.GetDuration("jwt.expire"); d > 0 {
+		return d
+	}
+	return defaultTokenExpire
+}
+
+// RefreshTokenExpire refresh token及sid的有效期，每次刷新后顺延，配置项 jwt.refreshExpire，默认7天
+func RefreshTokenExpire() time.Duration {
+	if d := config.GetDuration("jwt.refreshExpire"); d > 0 {
+		return d
+	}
+	return defaultRefreshTokenExpire
+}
+
+// Authenticate 校验用户名密码，成功返回对应用户
+func Authenticate(username, password string) (*domain.User, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	user := new(domain.User)
+	has, err := database.DB.Where("username = ?", username).Get(user)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, ErrInvalidCredentials
+	}
+	ok, rehashed, err := user.CheckPassword(password)
+	if err != nil {
+		logger.Errorf("用户[%s]密码校验失败: %v", user.Id, err)
+		return nil, ErrInvalidCredentials
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if rehashed {
+		// 旧算法或参数较弱的密码，校验通过后透明升级，需带上版本号
+		if _, err = database.DB.ID(user.Id).Cols("password").Update(&domain.User{
+			Password:  user.Password,
+			BaseModel: domain.BaseModel{Version: user.Version},
+		}); err != nil {
+			logger.Errorf("用户[%s]密码重新散列后保存失败: %v", user.Id, err)
+		}
+	}
+	return user, nil
+}
+
+// checkTenant 租户由客户端传入，需确认用户属于该租户，见 authorization.IsTenantMember
+func checkTenant(uid, tenantId string) error {
+	if tenantId == "" {
+		return nil
+	}
+	ok, err := authorization.IsTenantMember(uid, tenantId)
+	if err != nil {
+		return apperr.ErrService.WithCause(err)
+	}
+	if !ok {
+		return ErrTenantDenied
+	}
+	return nil
+}
+
+// IssueToken 为用户签发访问token及refresh token，同时在缓存中记录会话
+// tenantId不为空时用户需属于该租户，否则返回 ErrTenantDenied
+func IssueToken(uid, tenantId, ip, userAgent string) (pair *TokenPair, sid string, err error) {
+	if err = checkTenant(uid, tenantId); err != nil {
+		return nil, "", err
+	}
+	sid = util.GenerateRequestID()
+	jti := util.GenerateRequestID()
+	pair, err = signTokenPair(uid, sid, tenantId, jti)
+	if err != nil {
+		return nil, "", err
+	}
+	err = session.Create(&session.Session{
+		Sid:       sid,
+		UserId:    uid,
+		TenantId:  tenantId,
+		Ip:        ip,
+		UserAgent: userAgent,
+	}, jti, RefreshTokenExpire())
+	if err != nil {
+		return nil, "", err
+	}
+	return
+}
+
+// RefreshToken 使用refresh token换取新的token对，旧的refresh token随即作废
+// 已作废的refresh token再次使用时视为被盗用，整个会话将被注销
+// 依赖redis检测重放，未启用时返回 ErrRefreshUnavailable
+func RefreshToken(refreshToken string) (*TokenPair, error) {
+	token, err := ParseJwt(refreshToken)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	typ, _ := claims["typ"].(string)
+	uid, _ := claims["uid"].(string)
+	sid, _ := claims["sid"].(string)
+	jti, _ := claims["jti"].(string)
+	tenantId, _ := claims["tenantId"].(string)
+	if typ != tokenTypeRefresh || uid == "" || sid == "" || jti == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// 刷新时重新确认租户，用户移出租户后无法继续续期
+	if err = checkTenant(uid, tenantId); err != nil {
+		return nil, err
+	}
+
+	newJti := util.GenerateRequestID()
+	pair, err := signTokenPair(uid, sid, tenantId, newJti)
+	if err != nil {
+		return nil, err
+	}
+	result, err := session.Rotate(sid, uid, jti, newJti, RefreshTokenExpire())
+	if err != nil {
+		if err == session.ErrCacheDisabled {
+			return nil, ErrRefreshUnavailable
+		}
+		return nil, err
+	}
+	switch result {
+	case session.RotateMissing:
+		return nil, ErrInvalidRefreshToken
+	case session.RotateReused:
+		logger.Warnf("检测到refresh token重放，已注销会话 uid=%s sid=%s", uid, sid)
+		return nil, ErrRefreshTokenReused
+	}
+	return pair, nil
+}
+
+func signTokenPair(uid, sid, tenantId, jti string) (*TokenPair, error) {
+	now := time.Now()
+	expire := TokenExpire()
+	refreshExpire := RefreshTokenExpire()
+	claims := jwt.MapClaims{
+		"uid": uid,
+		"sid": sid,
+		"typ": tokenTypeAccess,
+		"iat": now.Unix(),
+		"exp": now.Add(expire).Unix(),
+	}
+	refreshClaims := jwt.MapClaims{
+		"uid": uid,
+		"sid": sid,
+		"jti": jti,
+		"typ": tokenTypeRefresh,
+		"iat": now.Unix(),
+		"exp": now.Add(refreshExpire).Unix(),
+	}
+	if tenantId != "" {
+		claims["tenantId"] = tenantId
+		refreshClaims["tenantId"] = tenantId
+	}
+	token, err := SignJwt(claims)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := SignJwt(refreshClaims)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		Token:           token,
+		ExpireIn:        int64(expire / time.Second),
+		RefreshToken:    refreshToken,
+		RefreshExpireIn: int64(refreshExpire / time.Second),
+	}, nil
+}
+
+// RevokeToken 注销会话，对应的token及refresh token随即失效
+func RevokeToken(sid string) error {
+	return session.Revoke(sid)
+}
+
+func setTokenCookies(ctx *fiber.Ctx, pair *TokenPair) {
+	now := time.Now()
+	ctx.Cookie(&fiber.Cookie{
+		Name:     TokenCookieName,
+		Value:    pair.Token,
+		Path:     "/",
+		Expires:  now.Add(time.Duration(pair.ExpireIn) * time.Second),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	ctx.Cookie(&fiber.Cookie{
+		Name:     RefreshTokenCookieName,
+		Value:    pair.RefreshToken,
+		Path:     "/",
+		Expires:  now.Add(time.Duration(pair.RefreshExpireIn) * time.Second),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+}
+
+func clearTokenCookies(ctx *fiber.Ctx) {
+	for _, name := range []string{TokenCookieName, RefreshTokenCookieName} {
+		ctx.Cookie(&fiber.Cookie{
+			Name:     name,
+			Path:     "/",
+			Expires:  time.Unix(0, 0),
+			HTTPOnly: true,
+		})
+	}
+}
+
+func LoginHandler(ctx *fiber.Ctx) error {
+	req := new(LoginRequest)
+	if err := ctx.BodyParser(req); err != nil {
+		return apperr.ErrBodyParse.WithCause(err)
+	}
+	if req.Username == "" || req.Password == "" {
+		return apperr.ErrLackOfField.WithMsg("auth.lackOfCredentials")
+	}
+	user, err := Authenticate(req.Username, req.Password)
+	if err != nil {
+		return err
+	}
+
+	pair, _, err := IssueToken(user.Id, req.TenantId, GetClientIp(ctx), ctx.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		return err
+	}
+	setTokenCookies(ctx, pair)
+	user.Sanitize()
+	return ctx.JSON(&domain.CommonResponse{
+		Data: &LoginResponse{
+			TokenPair: pair,
+			User:      user,
+		},
+	})
+}
+
+// RefreshHandler refresh token可通过请求体或cookie传入
+func RefreshHandler(ctx *fiber.Ctx) error {
+	req := new(RefreshRequest)
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(req); err != nil {
+			return apperr.ErrBodyParse.WithCause(err)
+		}
+	}
+	if req.RefreshToken == "" {
+		req.RefreshToken = ctx.Cookies(RefreshTokenCookieName)
+	}
+	if req.RefreshToken == "" {
+		return apperr.ErrLackOfField.WithMsg("auth.lackOfRefreshToken")
+	}
+	pair, err := RefreshToken(req.RefreshToken)
+	if err != nil {
+		if err == ErrInvalidRefreshToken || err == ErrRefreshTokenReused || err == ErrRefreshUnavailable {
+			clearTokenCookies(ctx)
+		}
+		return err
+	}
+	setTokenCookies(ctx, pair)
+	return ctx.JSON(&domain.CommonResponse{
+		Data: pair,
+	})
+}
+
+// LogoutHandler 需在Jwtware之后使用
+func LogoutHandler(ctx *fiber.Ctx) error {
+	sid, _ := ctx.Locals("sid").(string)
+	if err := RevokeToken(sid); err != nil {
+		return err
+	}
+	clearTokenCookies(ctx)
+	return ctx.JSON(&domain.CommonResponse{})
+}
+
+// AuthRouter 登录相关路由，prefix/login、prefix/refresh、prefix/logout 以及公钥 prefix/jwks
+func (a *webApp) AuthRouter(prefix string) fiber.Router {
+	g := a.Group(prefix, false, false)
+	g.Post("/login", LoginHandler)
+	g.Post("/refresh", RefreshHandler)
+	g.Post("/logout", Jwtware, LogoutHandler)
+	g.Get("/jwks", JwksHandler)
+	return g
+}
+
+func AuthRouter(prefix string) fiber.Router {
+	return defaultApp.AuthRouter(prefix)
+}
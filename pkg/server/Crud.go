@@ -0,0 +1,477 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"xorm.io/builder"
+	"xorm.io/xorm"
+
+	"github.com/yockii/qscore/pkg/apperr"
+	"github.com/yockii/qscore/pkg/database"
+	"github.com/yockii/qscore/pkg/domain"
+	"github.com/yockii/qscore/pkg/util"
+	"github.com/yockii/qscore/pkg/validator"
+)
+
+// CrudHook model为模型指针，返回错误时中止处理并返回该错误
+type CrudHook func(ctx *fiber.Ctx, model interface{}) error
+
+// CrudListHook req为请求结构体指针，可在session上追加查询条件
+type CrudListHook func(ctx *fiber.Ctx, req interface{}, session *xorm.Session) error
+
+type CrudHooks struct {
+	BeforeAdd    CrudHook
+	AfterAdd     CrudHook
+	BeforeUpdate CrudHook
+	AfterUpdate  CrudHook
+	// BeforeDelete/AfterDelete 的model仅包含Id
+	BeforeDelete CrudHook
+	AfterDelete  CrudHook
+	AfterGet     CrudHook
+	BeforeList   CrudListHook
+	// AfterList items为模型指针的切片
+	AfterList func(ctx *fiber.Ctx, items interface{}) error
+}
+
+// Crud 通用增删改查，模型需包含string类型的Id字段
+//
+//	crud := server.NewCrud(domain.Dict{}, domain.DictRequest{}, domain.DictIdPrefix)
+//	crud.Hooks.BeforeAdd = func(ctx *fiber.Ctx, model interface{}) error { ... }
+//	crud.Router("/dict")
+//
+// 新增/修改时请求体按 validator.SceneAdd / validator.SceneUpdate 场景校验
+// 列表查询时模型中声明了 filter:"eq" 的非零字段作为等值条件(见 ModelCond)，请求中的 CreateTimeRange 作为创建时间范围条件
+// 模型嵌入 domain.TenantModel 时按登录用户的租户隔离数据，跨租户访问见 CrossTenant
+// 模型嵌入 domain.BaseModel 时自动填充创建人/更新人，删除为软删除，修改时校验版本号，版本号不一致返回 apperr.ErrConflict
+// 模型实现 domain.PasswordHashable 时新增/修改前散列密码(在BeforeAdd/BeforeUpdate之前，Hooks中无需再散列)，
+// 实现 domain.Sanitizable 时所有返回结果均清除敏感字段，如 domain.User 的密码
+type Crud struct {
+	IdPrefix string
+	Hooks    CrudHooks
+	// Datasource 使用的具名数据源，为空时使用默认数据源，配置了从库时查询接口路由到从库
+	Datasource string
+
+	modelType   reflect.Type
+	requestType reflect.Type
+	// modelIndex 模型在请求结构体中的字段位置，请求类型即模型类型时为nil
+	modelIndex    []int
+	hasCreateTime bool
+	hasVersion    bool
+}
+
+// NewCrud request为嵌入了model的请求结构体，可为nil，此时直接使用model解析请求
+func NewCrud(model, request interface{}, idPrefix string) *Crud {
+	c := &Crud{
+		IdPrefix:  idPrefix,
+		modelType: indirectType(reflect.TypeOf(model)),
+	}
+	if f, ok := c.modelType.FieldByName("Id"); !ok || f.Type.Kind() != reflect.String {
+		panic(fmt.Sprintf("crud: %s 缺少string类型的Id字段", c.modelType.Name()))
+	}
+	_, c.hasCreateTime = c.modelType.FieldByName("CreateTime")
+	_, c.hasVersion = c.modelType.FieldByName("Version")
+
+	c.requestType = c.modelType
+	if request != nil {
+		c.requestType = indirectType(reflect.TypeOf(request))
+	}
+	if c.requestType != c.modelType {
+		for i := 0; i < c.requestType.NumField(); i++ {
+			f := c.requestType.Field(i)
+			if f.Anonymous && f.Type == c.modelType {
+				c.modelIndex = f.Index
+				break
+			}
+		}
+		if c.modelIndex == nil {
+			panic(fmt.Sprintf("crud: %s 未嵌入 %s", c.requestType.Name(), c.modelType.Name()))
+		}
+	}
+	return c
+}
+
+func (c *Crud) engine() xorm.EngineInterface {
+	return database.Engine(c.Datasource)
+}
+
+// session 新建按租户隔离的session，使用后需Close
+func (c *Crud) session(ctx *fiber.Ctx) (*xorm.Session, error) {
+	return c.newSession(ctx, c.engine())
+}
+
+// readSession 只读查询(Get、Paginate、CursorPaginate)使用的session，配置了从库时路由到从库，见 database.ReadEngine
+func (c *Crud) readSession(ctx *fiber.Ctx) (*xorm.Session, error) {
+	return c.newSession(ctx, database.ReadEngine(c.Datasource))
+}
+
+func (c *Crud) newSession(ctx *fiber.Ctx, engine xorm.EngineInterface) (*xorm.Session, error) {
+	if engine == nil {
+		return nil, apperr.ErrService.WithCause(fmt.Errorf("数据源 %s 不存在", c.Datasource))
+	}
+	session := engine.NewSession()
+	if err := c.scope(ctx, session); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// scope 为session追加租户条件，session每次执行后条件即被清空，再次使用前需重新追加
+func (c *Crud) scope(ctx *fiber.Ctx, session *xorm.Session) error {
+	if err := database.ApplyTenant(TenantContext(ctx), session, c.newModel()); err != nil {
+		return tenantError(err)
+	}
+	return nil
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func (c *Crud) newModel() interface{} {
+	return reflect.New(c.modelType).Interface()
+}
+
+// newRequest 返回请求结构体指针及其中的模型指针
+func (c *Crud) newRequest() (req interface{}, model interface{}) {
+	rv := reflect.New(c.requestType)
+	if c.modelIndex == nil {
+		return rv.Interface(), rv.Interface()
+	}
+	return rv.Interface(), rv.Elem().FieldByIndex(c.modelIndex).Addr().Interface()
+}
+
+func getId(model interface{}) string {
+	return reflect.ValueOf(model).Elem().FieldByName("Id").String()
+}
+
+func setId(model interface{}, id string) {
+	reflect.ValueOf(model).Elem().FieldByName("Id").SetString(id)
+}
+
+// setAuditor 模型实现 domain.Auditable 时以当前登录用户填充创建人/更新人，修改时清空请求中的创建人
+func setAuditor(ctx *fiber.Ctx, model interface{}, adding bool) {
+	auditable, ok := model.(domain.Auditable)
+	if !ok {
+		return
+	}
+	uid, _ := ctx.Locals("userId").(string)
+	if adding {
+		auditable.SetCreatorId(uid)
+	} else {
+		auditable.SetCreatorId("")
+	}
+	auditable.SetUpdaterId(uid)
+}
+
+func hashPassword(model interface{}) error {
+	if h, ok := model.(domain.PasswordHashable); ok {
+		if err := h.HashPassword(); err != nil {
+			return apperr.ErrService.WithCause(err)
+		}
+	}
+	return nil
+}
+
+// sanitize v为模型指针或模型指针的切片
+func sanitize(v interface{}) {
+	if s, ok := v.(domain.Sanitizable); ok {
+		s.Sanitize()
+		return
+	}
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Slice {
+		return
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if s, ok := rv.Index(i).Interface().(domain.Sanitizable); ok {
+			s.Sanitize()
+		}
+	}
+}
+
+func runHook(hook CrudHook, ctx *fiber.Ctx, model interface{}) error {
+	if hook == nil {
+		return nil
+	}
+	return hook(ctx, model)
+}
+
+func (c *Crud) Add(ctx *fiber.Ctx) error {
+	req, model := c.newRequest()
+	if err := ParseBody(ctx, req, validator.SceneAdd); err != nil {
+		return err
+	}
+	setId(model, c.IdPrefix+util.GenerateDatabaseID())
+	setAuditor(ctx, model, true)
+	if err := database.FillTenant(TenantContext(ctx), model); err != nil {
+		return tenantError(err)
+	}
+	if err := hashPassword(model); err != nil {
+		return err
+	}
+	if err := runHook(c.Hooks.BeforeAdd, ctx, model); err != nil {
+		return err
+	}
+	if _, err := c.engine().Insert(model); err != nil {
+		return apperr.ErrService.WithCause(err)
+	}
+	if err := runHook(c.Hooks.AfterAdd, ctx, model); err != nil {
+		return err
+	}
+	sanitize(model)
+	return ctx.JSON(&domain.CommonResponse{
+		Data: model,
+	})
+}
+
+// Update 仅更新非零值字段，模型有Version字段时需带上读取到的版本号
+func (c *Crud) Update(ctx *fiber.Ctx) error {
+	req, model := c.newRequest()
+	if err := ParseBody(ctx, req, validator.SceneUpdate); err != nil {
+		return err
+	}
+	id := getId(model)
+	if id == "" {
+		return apperr.ErrLackOfField.WithMsg("error.required", "id")
+	}
+	session, err := c.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	has, err := session.ID(id).Exist(c.newModel())
+	if err != nil {
+		return apperr.ErrService.WithCause(err)
+	}
+	if !has {
+		return apperr.ErrNotFound
+	}
+	setAuditor(ctx, model, false)
+	if err = database.FillTenant(TenantContext(ctx), model); err != nil {
+		return tenantError(err)
+	}
+	if err = hashPassword(model); err != nil {
+		return err
+	}
+	if err = runHook(c.Hooks.BeforeUpdate, ctx, model); err != nil {
+		return err
+	}
+	if err = c.scope(ctx, session); err != nil {
+		return err
+	}
+	session.ID(id)
+	if _, ok := model.(domain.Auditable); ok {
+		session.Omit("creator_id")
+	}
+	affected, err := session.Update(model)
+	if err != nil {
+		return apperr.ErrService.WithCause(err)
+	}
+	// 数据存在但未更新，说明版本号已变化
+	if affected == 0 && c.hasVersion {
+		return apperr.ErrConflict
+	}
+	if err = runHook(c.Hooks.AfterUpdate, ctx, model); err != nil {
+		return err
+	}
+	sanitize(model)
+	return ctx.JSON(&domain.CommonResponse{
+		Data: model,
+	})
+}
+
+// Delete DELETE prefix/?id=，模型有 xorm:"deleted" 字段时为软删除
+func (c *Crud) Delete(ctx *fiber.Ctx) error {
+	id := ctx.Query("id")
+	if id == "" {
+		return apperr.ErrLackOfField.WithMsg("error.required", "id")
+	}
+	model := c.newModel()
+	setId(model, id)
+	if err := runHook(c.Hooks.BeforeDelete, ctx, model); err != nil {
+		return err
+	}
+	session, err := c.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	count, err := session.ID(id).Delete(c.newModel())
+	if err != nil {
+		return apperr.ErrService.WithCause(err)
+	}
+	if count == 0 {
+		return apperr.ErrNotFound
+	}
+	if err = runHook(c.Hooks.AfterDelete, ctx, model); err != nil {
+		return err
+	}
+	return ctx.JSON(&domain.CommonResponse{})
+}
+
+// Get GET prefix/instance?id=
+func (c *Crud) Get(ctx *fiber.Ctx) error {
+	id := ctx.Query("id")
+	if id == "" {
+		return apperr.ErrLackOfField.WithMsg("error.required", "id")
+	}
+	session, err := c.readSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	model := c.newModel()
+	has, err := session.ID(id).Get(model)
+	if err != nil {
+		return apperr.ErrService.WithCause(err)
+	}
+	if !has {
+		return apperr.ErrNotFound
+	}
+	if err = runHook(c.Hooks.AfterGet, ctx, model); err != nil {
+		return err
+	}
+	sanitize(model)
+	return ctx.JSON(&domain.CommonResponse{
+		Data: model,
+	})
+}
+
+// Paginate GET prefix/list?limit=&offset=&filter=&orderBy=，过滤见 ParseFilter，分页及排序见 ParsePaginationInfoFromQuery
+// 未指定排序时按id倒序，即创建时间倒序
+func (c *Crud) Paginate(ctx *fiber.Ctx) error {
+	req, model := c.newRequest()
+	if err := ParseQuery(ctx, req); err != nil {
+		return err
+	}
+	limit, offset, orderBy, err := ParsePaginationInfoFromQuery(ctx, model)
+	if err != nil {
+		if _, ok := apperr.As(err); ok {
+			return err
+		}
+		return apperr.ErrInvalid.WithCause(err)
+	}
+	cond, err := ParseFilter(strings.Join(queryValues(ctx, "filter"), ","), model)
+	if err != nil {
+		return err
+	}
+
+	session, err := c.readSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if err = c.applyListConds(ctx, req, cond.And(ModelCond(model)), session); err != nil {
+		return err
+	}
+	if orderBy == "" {
+		orderBy = "id DESC"
+	}
+	session.OrderBy(orderBy)
+	if limit > 0 {
+		session.Limit(limit, offset)
+	}
+
+	items := reflect.New(reflect.SliceOf(reflect.PtrTo(c.modelType)))
+	total, err := session.FindAndCount(items.Interface())
+	if err != nil {
+		return apperr.ErrService.WithCause(err)
+	}
+	if c.Hooks.AfterList != nil {
+		if err = c.Hooks.AfterList(ctx, items.Elem().Interface()); err != nil {
+			return err
+		}
+	}
+	sanitize(items.Interface())
+	return ctx.JSON(&domain.CommonResponse{
+		Data: &domain.Paginate{
+			Total:  int(total),
+			Offset: offset,
+			Limit:  limit,
+			Items:  items.Elem().Interface(),
+		},
+	})
+}
+
+// CursorPaginate GET prefix/cursor?limit=&cursor=&count=&filter=，按id游标分页，不支持自定义排序
+// 该接口不在 Router 中注册，需要时自行注册：crud.Router("/dict").Get("/cursor", crud.CursorPaginate)
+func (c *Crud) CursorPaginate(ctx *fiber.Ctx) error {
+	req, model := c.newRequest()
+	if err := ParseQuery(ctx, req); err != nil {
+		return err
+	}
+	info, err := ParseCursorInfoFromQuery(ctx)
+	if err != nil {
+		return err
+	}
+	cond, err := ParseFilter(strings.Join(queryValues(ctx, "filter"), ","), model)
+	if err != nil {
+		return err
+	}
+
+	session, err := c.readSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if err = c.applyListConds(ctx, req, cond.And(ModelCond(model)), session); err != nil {
+		return err
+	}
+	items := reflect.New(reflect.SliceOf(reflect.PtrTo(c.modelType)))
+	rst, err := FindByCursor(session, info, items.Interface())
+	if err != nil {
+		return err
+	}
+	if c.Hooks.AfterList != nil {
+		if err = c.Hooks.AfterList(ctx, rst.Items); err != nil {
+			return err
+		}
+	}
+	sanitize(items.Interface())
+	return ctx.JSON(&domain.CommonResponse{
+		Data: rst,
+	})
+}
+
+// applyListConds 追加创建时间范围、过滤条件并执行 BeforeList
+func (c *Crud) applyListConds(ctx *fiber.Ctx, req interface{}, cond builder.Cond, session *xorm.Session) error {
+	if tc := c.createTimeRange(req); tc != nil && c.hasCreateTime {
+		if !tc.Start.IsZero() {
+			session.And("create_time >= ?", time.Time(tc.Start))
+		}
+		if !tc.End.IsZero() {
+			session.And("create_time <= ?", time.Time(tc.End))
+		}
+	}
+	session.And(cond)
+	if c.Hooks.BeforeList != nil {
+		return c.Hooks.BeforeList(ctx, req, session)
+	}
+	return nil
+}
+
+func (c *Crud) createTimeRange(req interface{}) *domain.TimeCondition {
+	f := reflect.ValueOf(req).Elem().FieldByName("CreateTimeRange")
+	if !f.IsValid() {
+		return nil
+	}
+	tc, _ := f.Interface().(*domain.TimeCondition)
+	return tc
+}
+
+// Router 以 StandardRouter 注册增删改查路由
+func (c *Crud) Router(prefix string) fiber.Router {
+	return StandardRouter(prefix, c.Add, c.Update, c.Delete, c.Get, c.Paginate)
+}
+
+func (c *Crud) VersionRouter(version, prefix string) fiber.Router {
+	return StandardVersionRouter(version, prefix, c.Add, c.Update, c.Delete, c.Get, c.Paginate)
+}
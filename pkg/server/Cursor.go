@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+
+	"xorm.io/builder"
+	"xorm.io/xorm"
+
+	"github.com/yockii/qscore/pkg/apperr"
+	"github.com/yockii/qscore/pkg/domain"
+)
+
+// 基于id的游标分页，id由 IdPrefix + util.GenerateDatabaseID() 生成，同一张表前缀相同，按字符串排序即按创建时间排序
+// 结果按id倒序(新数据在前)，next指向更早的数据，prev指向更新的数据
+
+// Cursor 游标内容，序列化后以base64url编码，对调用方不透明
+type Cursor struct {
+	Id string `json:"id"`
+	// Prev 为true时表示向前(更新的数据)翻页
+	Prev bool `json:"prev,omitempty"`
+}
+
+type CursorInfo struct {
+	Limit  int
+	Cursor *Cursor
+	// WithCount 是否统计总数，大表建议关闭
+	WithCount bool
+}
+
+func EncodeCursor(c *Cursor) string {
+	bs, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, apperr.ErrInvalid.WithMsg("cursor.invalid").WithCause(err)
+	}
+	c := new(Cursor)
+	if err = json.Unmarshal(bs, c); err != nil || c.Id == "" {
+		return nil, apperr.ErrInvalid.WithMsg("cursor.invalid").WithCause(err)
+	}
+	return c, nil
+}
+
+func init() {
+	apperr.RegisterMessages(apperr.LangZh, map[string]string{
+		"cursor.invalid": "分页游标不合法",
+	})
+	apperr.RegisterMessages(apperr.LangEn, map[string]string{
+		"cursor.invalid": "Invalid pagination cursor",
+	})
+}
+
+// FindByCursor 在session已有条件的基础上按游标查询一页数据，rowsSlicePtr为模型指针切片的指针
+// session中不应再设置排序及limit
+func FindByCursor(session *xorm.Session, info *CursorInfo, rowsSlicePtr interface{}, condiBean ...interface{}) (*domain.CursorPaginate, error) {
+	conds := session.Conds()
+	prev := info.Cursor != nil && info.Cursor.Prev
+	if info.Cursor != nil {
+		if prev {
+			session.And(builder.Gt{"id": info.Cursor.Id})
+		} else {
+			session.And(builder.Lt{"id": info.Cursor.Id})
+		}
+	}
+	if prev {
+		session.Asc("id")
+	} else {
+		session.Desc("id")
+	}
+	// 多查一条用于判断是否还有数据
+	if err := session.Limit(info.Limit+1).Find(rowsSlicePtr, condiBean...); err != nil {
+		return nil, apperr.ErrService.WithCause(err)
+	}
+
+	items := reflect.ValueOf(rowsSlicePtr).Elem()
+	more := items.Len() > info.Limit
+	if more {
+		items.Set(items.Slice(0, info.Limit))
+	}
+	if prev {
+		for i, j := 0, items.Len()-1; i < j; i, j = i+1, j-1 {
+			a, b := items.Index(i).Interface(), items.Index(j).Interface()
+			items.Index(i).Set(reflect.ValueOf(b))
+			items.Index(j).Set(reflect.ValueOf(a))
+		}
+	}
+
+	rst := &domain.CursorPaginate{
+		Limit: info.Limit,
+		Items: items.Interface(),
+	}
+	if n := items.Len(); n > 0 {
+		// 向后翻页时还有更多数据，或者向前翻页(来时的数据必然存在)
+		if more && !prev || prev {
+			rst.Next = EncodeCursor(&Cursor{Id: itemId(items.Index(n - 1))})
+		}
+		// 向前翻页时还有更多数据，或者从某一游标向后翻页
+		if more && prev || !prev && info.Cursor != nil {
+			rst.Prev = EncodeCursor(&Cursor{Id: itemId(items.Index(0)), Prev: true})
+		}
+	}
+
+	if info.WithCount {
+		var bean interface{}
+		if len(condiBean) > 0 {
+			bean = condiBean[0]
+		} else {
+			bean = reflect.New(indirectType(items.Type().Elem())).Interface()
+		}
+		total, err := session.Where(conds).Count(bean)
+		if err != nil {
+			return nil, apperr.ErrService.WithCause(err)
+		}
+		t := int(total)
+		rst.Total = &t
+	}
+	return rst, nil
+}
+
+func itemId(v reflect.Value) string {
+	return reflect.Indirect(v).FieldByName("Id").String()
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/yockii/qscore/pkg/apperr"
+	"github.com/yockii/qscore/pkg/config"
+	"github.com/yockii/qscore/pkg/domain"
+	"github.com/yockii/qscore/pkg/logger"
+)
+
+// ErrorHandler 将handler返回的错误统一转换为 domain.CommonResponse
+// 非 apperr.Error 的错误视为服务异常，原始错误仅在配置项 server.debug 为true时返回给调用方
+func ErrorHandler(ctx *fiber.Ctx, err error) error {
+	e, ok := apperr.As(err)
+	if !ok {
+		if fe, isFiberError := err.(*fiber.Error); isFiberError {
+			e = fiberError(fe)
+		} else {
+			e = apperr.ErrService.WithCause(err)
+		}
+	}
+
+	if e.Status >= fiber.StatusInternalServerError {
+		logger.Errorf("%s %s 处理失败: %v", ctx.Method(), ctx.OriginalURL(), e)
+	} else if e.Cause != nil {
+		logger.Debugf("%s %s 请求被拒绝: %v", ctx.Method(), ctx.OriginalURL(), e)
+	}
+
+	lang := Lang(ctx)
+	resp := &domain.CommonResponse{
+		Code: e.Code,
+		Msg:  e.Message(lang),
+		Data: e.LocalizedData(lang),
+	}
+	if e.Cause != nil && e.Data == nil && config.GetBool("server.debug") {
+		resp.Data = fiber.Map{"cause": e.Cause.Error()}
+	}
+	return ctx.Status(e.Status).JSON(resp)
+}
+
+func fiberError(fe *fiber.Error) *apperr.Error {
+	var e *apperr.Error
+	switch fe.Code {
+	case fiber.StatusNotFound:
+		e = apperr.ErrNotFound
+	case fiber.StatusUnauthorized:
+		e = apperr.ErrUnauthorized
+	case fiber.StatusForbidden:
+		e = apperr.ErrReject
+	default:
+		if fe.Code >= fiber.StatusInternalServerError {
+			e = apperr.ErrService
+		} else {
+			e = apperr.ErrInvalid
+		}
+	}
+	e = e.WithCause(fe)
+	e.Status = fe.Code
+	return e
+}
+
+// Lang 根据Accept-Language获取响应语言，默认 apperr.DefaultLang
+func Lang(ctx *fiber.Ctx) string {
+	if lang := ctx.AcceptsLanguages(apperr.LangZh, apperr.LangEn); lang != "" {
+		return lang
+	}
+	return apperr.DefaultLang
+}
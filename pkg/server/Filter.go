@@ -0,0 +1,277 @@
+package server
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"xorm.io/builder"
+	"xorm.io/xorm/names"
+
+	"github.com/yockii/qscore/pkg/apperr"
+	"github.com/yockii/qscore/pkg/database"
+	"github.com/yockii/qscore/pkg/domain"
+)
+
+// 列表查询的过滤及排序，字段名使用json名，可用的字段及操作符由模型的 filter 标签声明：
+//
+//	Username   string   `json:"username" filter:"eq,like,sort"`
+//	CreateTime DateTime `json:"createTime" filter:"gte,lte,sort"`
+//
+// 查询参数：filter=username:like:adm,createTime:gte:2021-01-01 00:00:00&orderBy=createTime-desc
+// filter可重复传入，多个条件之间为AND关系；in操作的多个值以|分隔
+// 操作符：eq ne gt gte lt lte like in，sort表示允许按该字段排序
+// like的值作为子串匹配，其中的 % _ 按普通字符处理
+
+const (
+	FilterOpEq   = "eq"
+	FilterOpNe   = "ne"
+	FilterOpGt   = "gt"
+	FilterOpGte  = "gte"
+	FilterOpLt   = "lt"
+	FilterOpLte  = "lte"
+	FilterOpLike = "like"
+	FilterOpIn   = "in"
+	filterSort   = "sort"
+)
+
+type filterField struct {
+	// index 字段在模型中的位置，用于 ModelCond 取值
+	index    []int
+	column   string
+	typ      reflect.Type
+	ops      map[string]bool
+	sortable bool
+}
+
+var filterFieldCache sync.Map // reflect.Type -> map[string]*filterField
+
+func init() {
+	apperr.RegisterMessages(apperr.LangZh, map[string]string{
+		"filter.invalidSyntax": "查询条件格式错误：%s",
+		"filter.invalidField":  "不支持按%s查询",
+		"filter.invalidOp":     "字段%s不支持%s操作",
+		"filter.invalidValue":  "字段%s的值%s不合法",
+		"filter.invalidSort":   "不支持按%s排序",
+	})
+	apperr.RegisterMessages(apperr.LangEn, map[string]string{
+		"filter.invalidSyntax": "Invalid filter: %s",
+		"filter.invalidField":  "Filtering by %s is not allowed",
+		"filter.invalidOp":     "Operator %[2]s is not allowed on %[1]s",
+		"filter.invalidValue":  "Invalid value %[2]s for %[1]s",
+		"filter.invalidSort":   "Sorting by %s is not allowed",
+	})
+}
+
+// ParseFilterFromQuery 按模型的 filter 标签解析查询参数中的 filter 及 orderBy，返回参数化的查询条件及排序语句
+func ParseFilterFromQuery(ctx *fiber.Ctx, model interface{}) (cond builder.Cond, orderBy string, err error) {
+	if cond, err = ParseFilter(strings.Join(queryValues(ctx, "filter"), ","), model); err != nil {
+		return
+	}
+	orderBy, err = ParseOrderBy(ctx.Query("orderBy"), model)
+	return
+}
+
+func queryValues(ctx *fiber.Ctx, key string) []string {
+	var values []string
+	for _, v := range ctx.Context().QueryArgs().PeekMulti(key) {
+		values = append(values, string(v))
+	}
+	return values
+}
+
+// ParseFilter 解析 field:op:value 形式、以逗号分隔的过滤条件
+func ParseFilter(filter string, model interface{}) (builder.Cond, error) {
+	cond := builder.NewCond()
+	if strings.TrimSpace(filter) == "" {
+		return cond, nil
+	}
+	fields := filterFields(model)
+	for _, item := range strings.Split(filter, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) != 3 {
+			return nil, apperr.ErrInvalid.WithMsg("filter.invalidSyntax", item)
+		}
+		name, op, raw := strings.TrimSpace(parts[0]), strings.ToLower(strings.TrimSpace(parts[1])), parts[2]
+		field, ok := fields[name]
+		if !ok || len(field.ops) == 0 {
+			return nil, apperr.ErrInvalid.WithMsg("filter.invalidField", name)
+		}
+		if !field.ops[op] {
+			return nil, apperr.ErrInvalid.WithMsg("filter.invalidOp", name, op)
+		}
+		if op == FilterOpIn {
+			var values []interface{}
+			for _, s := range strings.Split(raw, "|") {
+				v, err := convertFilterValue(field.typ, s)
+				if err != nil {
+					return nil, apperr.ErrInvalid.WithMsg("filter.invalidValue", name, s)
+				}
+				values = append(values, v)
+			}
+			cond = cond.And(builder.In(field.column, values...))
+			continue
+		}
+		if op == FilterOpLike {
+			cond = cond.And(likeCond(field.column, raw))
+			continue
+		}
+		v, err := convertFilterValue(field.typ, raw)
+		if err != nil {
+			return nil, apperr.ErrInvalid.WithMsg("filter.invalidValue", name, raw)
+		}
+		switch op {
+		case FilterOpEq:
+			cond = cond.And(builder.Eq{field.column: v})
+		case FilterOpNe:
+			cond = cond.And(builder.Neq{field.column: v})
+		case FilterOpGt:
+			cond = cond.And(builder.Gt{field.column: v})
+		case FilterOpGte:
+			cond = cond.And(builder.Gte{field.column: v})
+		case FilterOpLt:
+			cond = cond.And(builder.Lt{field.column: v})
+		case FilterOpLte:
+			cond = cond.And(builder.Lte{field.column: v})
+		}
+	}
+	return cond, nil
+}
+
+// likeEscaper 以!作为转义符，反斜杠在各数据库字符串中的含义不同
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func likeCond(column, value string) builder.Cond {
+	return builder.Expr(column+" LIKE ? ESCAPE '!'", "%"+likeEscaper.Replace(value)+"%")
+}
+
+// ModelCond 以模型中声明了 filter:"eq" 的非零字段构造等值条件，其他字段即使有值也被忽略
+func ModelCond(model interface{}) builder.Cond {
+	cond := builder.NewCond()
+	v := reflect.Indirect(reflect.ValueOf(model))
+	for _, field := range filterFields(model) {
+		if !field.ops[FilterOpEq] {
+			continue
+		}
+		fv := v.FieldByIndex(field.index)
+		if fv.IsZero() {
+			continue
+		}
+		if t, ok := fv.Interface().(domain.DateTime); ok {
+			cond = cond.And(builder.Eq{field.column: time.Time(t)})
+			continue
+		}
+		cond = cond.And(builder.Eq{field.column: fv.Interface()})
+	}
+	return cond
+}
+
+// ParseOrderBy 解析 a-desc,b-asc,c 形式的排序，仅允许标记了sort的字段
+func ParseOrderBy(orderBy string, model interface{}) (string, error) {
+	if strings.TrimSpace(orderBy) == "" {
+		return "", nil
+	}
+	fields := filterFields(model)
+	var obs []string
+	for _, s := range strings.Split(orderBy, ",") {
+		kds := strings.SplitN(strings.TrimSpace(s), "-", 2)
+		field, ok := fields[kds[0]]
+		if !ok || !field.sortable {
+			return "", apperr.ErrInvalid.WithMsg("filter.invalidSort", kds[0])
+		}
+		ob := field.column
+		if len(kds) == 2 {
+			switch strings.ToLower(kds[1]) {
+			case "desc":
+				ob += " DESC"
+			case "asc":
+			default:
+				return "", apperr.ErrInvalid.WithMsg("filter.invalidSort", s)
+			}
+		}
+		obs = append(obs, ob)
+	}
+	return strings.Join(obs, ", "), nil
+}
+
+func filterFields(model interface{}) map[string]*filterField {
+	t := indirectType(reflect.TypeOf(model))
+	if cached, ok := filterFieldCache.Load(t); ok {
+		return cached.(map[string]*filterField)
+	}
+	var mapper names.Mapper = names.SnakeMapper{}
+	if database.DB != nil {
+		mapper = database.DB.GetColumnMapper()
+	}
+	fields := make(map[string]*filterField)
+	collectFilterFields(t, nil, mapper, fields)
+	filterFieldCache.Store(t, fields)
+	return fields
+}
+
+func collectFilterFields(t reflect.Type, index []int, mapper names.Mapper, fields map[string]*filterField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			collectFilterFields(f.Type, fieldIndex, mapper, fields)
+			continue
+		}
+		tag, ok := f.Tag.Lookup("filter")
+		if !ok || tag == "-" {
+			continue
+		}
+		field := &filterField{
+			index:  fieldIndex,
+			column: mapper.Obj2Table(f.Name),
+			typ:    f.Type,
+			ops:    make(map[string]bool),
+		}
+		for _, op := range strings.Split(tag, ",") {
+			op = strings.TrimSpace(op)
+			if op == filterSort {
+				field.sortable = true
+			} else if op != "" {
+				field.ops[op] = true
+			}
+		}
+		fields[jsonName(f)] = field
+	}
+}
+
+func jsonName(f reflect.StructField) string {
+	if tag := f.Tag.Get("json"); tag != "" {
+		if name := strings.Split(tag, ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func convertFilterValue(t reflect.Type, raw string) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.ConvertibleTo(timeType) {
+		return time.ParseInLocation(domain.DateTimeFormat, raw, time.Local)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(raw, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(raw, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(raw, 64)
+	}
+	return raw, nil
+}
@@ -41,6 +41,7 @@ func InitWebApp(views fiber.Views) *webApp {
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
 		Views:                 views,
+		ErrorHandler:          ErrorHandler,
 	})
 	app.Use(recover.New(recover.Config{
 		EnableStackTrace: true,
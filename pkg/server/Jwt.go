@@ -0,0 +1,349 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/yockii/qscore/pkg/config"
+	"github.com/yockii/qscore/pkg/logger"
+)
+
+const defaultJwtKid = "default"
+
+// JwtKeyConfig 单个签名密钥配置，对应配置项 jwt.keys 的元素
+// 对称算法使用 secret，非对称算法使用 privateKey / publicKey，值可以是PEM内容或PEM文件路径
+// 只配置 publicKey 的密钥仅用于验证，适合轮换后保留旧密钥
+type JwtKeyConfig struct {
+	Kid        string `mapstructure:"kid"`
+	Algorithm  string `mapstructure:"algorithm"`
+	Secret     string `mapstructure:"secret"`
+	PrivateKey string `mapstructure:"privateKey"`
+	PublicKey  string `mapstructure:"publicKey"`
+}
+
+type jwtKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+type jwtKeyRing struct {
+	lock sync.RWMutex
+	// loadLock 串行化密钥加载，避免并发的首次请求各自生成随机密钥互相覆盖
+	loadLock sync.Mutex
+	loaded   bool
+	current  string
+	keys     map[string]*jwtKey
+	issuer   string
+	audience string
+}
+
+var defaultKeyRing = &jwtKeyRing{}
+
+// LoadFromConfig 从配置加载密钥
+// jwt.algorithm 默认算法，jwt.issuer / jwt.audience 签发及校验的iss、aud
+// jwt.secret / jwt.privateKey / jwt.publicKey 单密钥配置，kid为default
+// jwt.keys 多密钥配置，jwt.currentKid 指定签名使用的密钥，未指定则使用第一个可签名的密钥
+func (r *jwtKeyRing) LoadFromConfig() error {
+	r.loadLock.Lock()
+	defer r.loadLock.Unlock()
+	return r.load()
+}
+
+func (r *jwtKeyRing) load() error {
+	algorithm := config.GetString("jwt.algorithm")
+	if algorithm == "" {
+		algorithm = "HS256"
+	}
+	var keyConfigs []JwtKeyConfig
+	if err := config.UnmarshalKey("jwt.keys", &keyConfigs); err != nil {
+		return err
+	}
+	if len(keyConfigs) == 0 {
+		keyConfigs = append(keyConfigs, JwtKeyConfig{
+			Kid:        defaultJwtKid,
+			Secret:     config.GetString("jwt.secret"),
+			PrivateKey: config.GetString("jwt.privateKey"),
+			PublicKey:  config.GetString("jwt.publicKey"),
+		})
+	}
+
+	keys := make(map[string]*jwtKey)
+	current := config.GetString("jwt.currentKid")
+	for _, kc := range keyConfigs {
+		if kc.Kid == "" {
+			return errors.New("jwt密钥必须配置kid")
+		}
+		if kc.Algorithm == "" {
+			kc.Algorithm = algorithm
+		}
+		if kc.Secret == "" && kc.PrivateKey == "" && kc.PublicKey == "" {
+			continue
+		}
+		key, err := parseJwtKey(kc)
+		if err != nil {
+			return fmt.Errorf("jwt密钥[%s]解析失败: %v", kc.Kid, err)
+		}
+		keys[kc.Kid] = key
+		if current == "" && key.signKey != nil {
+			current = kc.Kid
+		}
+	}
+
+	if len(keys) == 0 {
+		logger.Warn("未配置jwt密钥，使用随机生成的HS256密钥，重启或多实例部署时token将失效")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		keys[defaultJwtKid] = &jwtKey{
+			kid:       defaultJwtKid,
+			method:    jwt.SigningMethodHS256,
+			signKey:   secret,
+			verifyKey: secret,
+		}
+		current = defaultJwtKid
+	}
+	if k, ok := keys[current]; !ok || k.signKey == nil {
+		return fmt.Errorf("jwt签名密钥[%s]不存在或缺少私钥", current)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.keys = keys
+	r.current = current
+	r.issuer = config.GetString("jwt.issuer")
+	r.audience = config.GetString("jwt.audience")
+	r.loaded = true
+	return nil
+}
+
+func (r *jwtKeyRing) ensureLoaded() error {
+	r.lock.RLock()
+	loaded := r.loaded
+	r.lock.RUnlock()
+	if loaded {
+		return nil
+	}
+	r.loadLock.Lock()
+	defer r.loadLock.Unlock()
+	r.lock.RLock()
+	loaded = r.loaded
+	r.lock.RUnlock()
+	if loaded {
+		return nil
+	}
+	return r.load()
+}
+
+// Sign 使用当前密钥签名，自动补充kid头以及iss、aud
+func (r *jwtKeyRing) Sign(claims jwt.MapClaims) (string, error) {
+	if err := r.ensureLoaded(); err != nil {
+		return "", err
+	}
+	r.lock.RLock()
+	key := r.keys[r.current]
+	issuer, audience := r.issuer, r.audience
+	r.lock.RUnlock()
+
+	if issuer != "" {
+		claims["iss"] = issuer
+	}
+	if audience != "" {
+		claims["aud"] = audience
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.signKey)
+}
+
+// Parse 校验token签名、有效期以及iss、aud
+func (r *jwtKeyRing) Parse(tokenString string) (*jwt.Token, error) {
+	if err := r.ensureLoaded(); err != nil {
+		return nil, err
+	}
+	token, err := jwt.Parse(tokenString, r.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	r.lock.RLock()
+	issuer, audience := r.issuer, r.audience
+	r.lock.RUnlock()
+	if issuer != "" && !claims.VerifyIssuer(issuer, true) {
+		return nil, errors.New("token签发者不匹配")
+	}
+	if audience != "" && !claims.VerifyAudience(audience, true) {
+		return nil, errors.New("token受众不匹配")
+	}
+	return token, nil
+}
+
+func (r *jwtKeyRing) keyFunc(t *jwt.Token) (interface{}, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		kid = r.current
+	}
+	key, ok := r.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("未知的jwt密钥 kid=%v", t.Header["kid"])
+	}
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("jwt签名算法不匹配 alg=%v", t.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+// Jwks 导出非对称密钥的公钥，对称密钥不导出
+func (r *jwtKeyRing) Jwks() (map[string]interface{}, error) {
+	if err := r.ensureLoaded(); err != nil {
+		return nil, err
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	keys := make([]map[string]string, 0, len(r.keys))
+	for _, key := range r.keys {
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"use": "sig",
+				"kid": key.kid,
+				"alg": key.method.Alg(),
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			keys = append(keys, map[string]string{
+				"kty": "EC",
+				"use": "sig",
+				"kid": key.kid,
+				"alg": key.method.Alg(),
+				"crv": pub.Curve.Params().Name,
+				"x":   base64.RawURLEncoding.EncodeToString(padBytes(pub.X.Bytes(), size)),
+				"y":   base64.RawURLEncoding.EncodeToString(padBytes(pub.Y.Bytes(), size)),
+			})
+		}
+	}
+	return map[string]interface{}{"keys": keys}, nil
+}
+
+func parseJwtKey(kc JwtKeyConfig) (*jwtKey, error) {
+	method := jwt.GetSigningMethod(kc.Algorithm)
+	if method == nil || method == jwt.SigningMethodNone {
+		return nil, fmt.Errorf("不支持的签名算法 %s", kc.Algorithm)
+	}
+	key := &jwtKey{kid: kc.Kid, method: method}
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if kc.Secret == "" {
+			return nil, errors.New("HMAC算法需要配置secret")
+		}
+		key.signKey = []byte(kc.Secret)
+		key.verifyKey = key.signKey
+	case *jwt.SigningMethodRSA:
+		if kc.PrivateKey != "" {
+			pem, err := readPem(kc.PrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = priv
+			key.verifyKey = &priv.PublicKey
+		} else {
+			pem, err := readPem(kc.PublicKey)
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+	case *jwt.SigningMethodECDSA:
+		if kc.PrivateKey != "" {
+			pem, err := readPem(kc.PrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseECPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = priv
+			key.verifyKey = &priv.PublicKey
+		} else {
+			pem, err := readPem(kc.PublicKey)
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseECPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("不支持的签名算法 %s", kc.Algorithm)
+	}
+	return key, nil
+}
+
+// readPem 配置值为PEM内容时直接使用，否则视为文件路径
+func readPem(v string) ([]byte, error) {
+	if v == "" {
+		return nil, errors.New("未配置密钥")
+	}
+	if strings.HasPrefix(strings.TrimSpace(v), "-----BEGIN") {
+		return []byte(v), nil
+	}
+	return os.ReadFile(v)
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+// ReloadJwtKeys 重新从配置加载密钥，用于密钥轮换
+func ReloadJwtKeys() error {
+	return defaultKeyRing.LoadFromConfig()
+}
+
+// SignJwt 使用当前密钥签发token
+func SignJwt(claims jwt.MapClaims) (string, error) {
+	return defaultKeyRing.Sign(claims)
+}
+
+// ParseJwt 校验并解析token
+func ParseJwt(token string) (*jwt.Token, error) {
+	return defaultKeyRing.Parse(token)
+}
+
+func JwksHandler(ctx *fiber.Ctx) error {
+	jwks, err := defaultKeyRing.Jwks()
+	if err != nil {
+		logger.Error(err)
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+	return ctx.JSON(jwks)
+}
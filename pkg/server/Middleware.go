@@ -1,73 +1,93 @@
 package server
 
 import (
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
-	jwtware "github.com/gofiber/jwt/v2"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/gomodule/redigo/redis"
 
+	"github.com/yockii/qscore/pkg/apperr"
 	"github.com/yockii/qscore/pkg/authorization"
 	"github.com/yockii/qscore/pkg/cache"
 
 	"github.com/yockii/qscore/pkg/constant"
 	"github.com/yockii/qscore/pkg/logger"
+	"github.com/yockii/qscore/pkg/session"
 )
 
-var Jwtware = jwtware.New(jwtware.Config{
-	SigningKey:    []byte(constant.JWT_SECRET),
-	ContextKey:    constant.JWT_CONTEXT,
-	SigningMethod: "HS256",
-	TokenLookup:   "header:Authorization,cookie:token",
-	ErrorHandler: func(c *fiber.Ctx, err error) error {
-		if err.Error() == "Missing or malformed JWT" {
-			return c.Status(fiber.StatusBadRequest).SendString("无效的token信息")
-		} else {
-			return c.Status(fiber.StatusUnauthorized).SendString("Invalid or expired Authorization Token")
-		}
-	},
-	SuccessHandler: func(c *fiber.Ctx) error {
-		// 从jwt获取用户信息
-		jwtToken := c.Locals(constant.JWT_CONTEXT).(*jwt.Token)
-		claims := jwtToken.Claims.(jwt.MapClaims)
-		uid := claims["uid"].(string)
-		sid := claims["sid"].(string)
-		tenantId, tenantOk := claims["tenantId"].(string)
+// Jwtware 校验请求中的token，依次从 Authorization 头及 token cookie 中获取
+// 签名密钥、算法及iss/aud通过配置项 jwt.* 设置，见 jwtKeyRing.LoadFromConfig
+var Jwtware fiber.Handler = func(c *fiber.Ctx) error {
+	tokenString := tokenFromRequest(c)
+	if tokenString == "" {
+		return apperr.ErrUnauthorized.WithMsg("auth.invalidToken")
+	}
+	jwtToken, err := ParseJwt(tokenString)
+	if err != nil {
+		return apperr.ErrUnauthorized.WithMsg("auth.expiredToken").WithCause(err)
+	}
+	c.Locals(constant.JWT_CONTEXT, jwtToken)
 
-		if cache.Enabled() {
-			rConn := cache.Get()
-			defer rConn.Close()
-			cachedUid, err := redis.String(rConn.Do("GET", cache.Prefix+":"+constant.AppSid+":"+sid))
-			if err != nil {
-				if err != redis.ErrNil {
-					logger.Error(err)
-				}
-				return c.Status(fiber.StatusUnauthorized).SendString("token信息失效")
-			}
-			if cachedUid != uid {
+	// 从jwt获取用户信息
+	claims := jwtToken.Claims.(jwt.MapClaims)
+	uid, _ := claims["uid"].(string)
+	sid, _ := claims["sid"].(string)
+	tenantId, tenantOk := claims["tenantId"].(string)
+	if typ, _ := claims["typ"].(string); typ == tokenTypeRefresh || uid == "" || sid == "" {
+		return apperr.ErrUnauthorized.WithMsg("auth.expiredToken")
+	}
+
+	if cache.Enabled() {
+		cachedUid, err := session.UserId(sid)
+		if err != nil {
+			if err != redis.ErrNil {
 				logger.Error(err)
-				return c.Status(fiber.StatusUnauthorized).SendString("token信息失效")
 			}
+			return apperr.ErrUnauthorized.WithMsg("auth.expiredToken")
+		}
+		if cachedUid != uid {
+			return apperr.ErrUnauthorized.WithMsg("auth.expiredToken")
 		}
+	}
 
-		c.Locals("userId", uid)
-		if tenantOk {
-			c.Locals("tenantId", tenantId)
+	// 签发时已校验租户，此处再次校验以便移出租户后立即生效，TenantContext 及权限校验均依赖该租户
+	if tenantOk {
+		if err = checkTenant(uid, tenantId); err != nil {
+			return err
 		}
-		return c.Next()
-	},
-})
+	}
+
+	c.Locals("userId", uid)
+	c.Locals("sid", sid)
+	if tenantOk {
+		c.Locals("tenantId", tenantId)
+	}
+	return c.Next()
+}
+
+func tokenFromRequest(c *fiber.Ctx) string {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return auth[7:]
+	}
+	return c.Cookies(TokenCookieName)
+}
 
+// RequireRouterPermission 以请求路径及方法在token的租户下校验权限，拒绝原因的日志见 logDenied
 func RequireRouterPermission() fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		path := ctx.Path()
 		method := ctx.Method()
-		subject := ctx.Locals("userId").(string)
+		subject, _ := ctx.Locals("userId").(string)
 		if subject == "" {
-			return ctx.SendStatus(fiber.StatusUnauthorized)
+			return apperr.ErrUnauthorized
 		}
-		if authorization.CheckSubjectPermissions(subject, path, method, "") {
+		tenantId, _ := ctx.Locals("tenantId").(string)
+		if authorization.CheckSubjectPermissions(subject, path, method, tenantId) {
 			return ctx.Next()
 		}
-		return ctx.SendStatus(fiber.StatusForbidden)
+		logDenied(subject, path, method, tenantId)
+		return apperr.ErrReject
 	}
 }
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/yockii/qscore/pkg/apperr"
+	"github.com/yockii/qscore/pkg/authorization"
+	"github.com/yockii/qscore/pkg/config"
+	"github.com/yockii/qscore/pkg/domain"
+	"github.com/yockii/qscore/pkg/logger"
+)
+
+func init() {
+	apperr.RegisterMessages(apperr.LangZh, map[string]string{
+		"permission.superAdminRequired": "仅超级管理员可访问",
+	})
+	apperr.RegisterMessages(apperr.LangEn, map[string]string{
+		"permission.superAdminRequired": "Super admin only",
+	})
+}
+
+// ExplainRequest 模拟校验的请求，Tenant为空时使用全局规则
+type ExplainRequest struct {
+	Subject  string `json:"subject" query:"subject"`
+	Resource string `json:"resource" query:"resource"`
+	Action   string `json:"action" query:"action"`
+	TenantId string `json:"tenantId" query:"tenantId"`
+}
+
+// RequireSuperAdmin 仅允许超级管理员访问，需在Jwtware之后使用
+func RequireSuperAdmin() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		subject, _ := ctx.Locals("userId").(string)
+		if subject == "" {
+			return apperr.ErrUnauthorized
+		}
+		isSuperAdmin, err := authorization.IsSuperAdmin(subject)
+		if err != nil {
+			return apperr.ErrService.WithCause(err)
+		}
+		if !isSuperAdmin {
+			return apperr.ErrReject.WithMsg("permission.superAdminRequired")
+		}
+		return ctx.Next()
+	}
+}
+
+// ExplainHandler 模拟一次权限校验并返回 authorization.Explanation，不实际访问资源
+func ExplainHandler(ctx *fiber.Ctx) error {
+	req := new(ExplainRequest)
+	if err := ctx.QueryParser(req); err != nil {
+		return apperr.ErrBodyParse.WithCause(err)
+	}
+	if req.Subject == "" || req.Resource == "" || req.Action == "" {
+		return apperr.ErrLackOfField
+	}
+	explanation, err := authorization.Explain(req.Subject, req.Resource, req.Action, req.TenantId)
+	if err != nil {
+		return apperr.ErrService.WithCause(err)
+	}
+	return ctx.JSON(&domain.CommonResponse{
+		Data: explanation,
+	})
+}
+
+// PermissionDebugRouter 权限排查接口 GET prefix/explain?subject=&resource=&action=&tenantId=，仅超级管理员可用
+func (a *webApp) PermissionDebugRouter(prefix string) fiber.Router {
+	g := a.Group(prefix, true, false)
+	g.Get("/explain", RequireSuperAdmin(), ExplainHandler)
+	return g
+}
+
+func PermissionDebugRouter(prefix string) fiber.Router {
+	return defaultApp.PermissionDebugRouter(prefix)
+}
+
+// logDenied 配置项 authorization.logDenied 为true时记录被拒绝的请求及原因
+func logDenied(subject, path, method, tenantId string) {
+	if !config.GetBool("authorization.logDenied") {
+		return
+	}
+	explanation, err := authorization.Explain(subject, path, method, tenantId)
+	if err != nil {
+		logger.Warnf("权限校验说明获取失败: %v", err)
+		return
+	}
+	logger.WithFields(logger.Fields{
+		"subject":  subject,
+		"resource": path,
+		"action":   method,
+		"tenantId": tenantId,
+		"roles":    explanation.Roles,
+		"policy":   explanation.Policy,
+	}).Warn("请求被拒绝")
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/yockii/qscore/pkg/apperr"
+	"github.com/yockii/qscore/pkg/domain"
+	"github.com/yockii/qscore/pkg/session"
+)
+
+func ListUserSessionsHandler(ctx *fiber.Ctx) error {
+	userId := ctx.Query("userId")
+	if userId == "" {
+		return apperr.ErrLackOfField.WithMsg("error.required", "userId")
+	}
+	sessions, err := session.ListByUser(userId)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(&domain.CommonResponse{
+		Data: sessions,
+	})
+}
+
+func RevokeSessionHandler(ctx *fiber.Ctx) error {
+	sid := ctx.Query("sid")
+	if sid == "" {
+		return apperr.ErrLackOfField.WithMsg("error.required", "sid")
+	}
+	if err := session.Revoke(sid); err != nil {
+		return err
+	}
+	return ctx.JSON(&domain.CommonResponse{})
+}
+
+func RevokeUserSessionsHandler(ctx *fiber.Ctx) error {
+	userId := ctx.Query("userId")
+	if userId == "" {
+		return apperr.ErrLackOfField.WithMsg("error.required", "userId")
+	}
+	count, err := session.RevokeUser(userId)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(&domain.CommonResponse{
+		Data: count,
+	})
+}
+
+// SessionRouter 会话管理路由，需要登录、校验权限
+// GET prefix/list?userId= 、DELETE prefix/?sid= 、DELETE prefix/user?userId=
+func (a *webApp) SessionRouter(prefix string) fiber.Router {
+	g := a.Group(prefix, true, true)
+	g.Get("/list", ListUserSessionsHandler)
+	g.Delete("/", RevokeSessionHandler)
+	g.Delete("/user", RevokeUserSessionsHandler)
+	return g
+}
+
+func SessionRouter(prefix string) fiber.Router {
+	return defaultApp.SessionRouter(prefix)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/yockii/qscore/pkg/apperr"
+	"github.com/yockii/qscore/pkg/authorization"
+	"github.com/yockii/qscore/pkg/database"
+)
+
+func init() {
+	apperr.RegisterMessages(apperr.LangZh, map[string]string{
+		"tenant.required":    "缺少租户信息",
+		"tenant.crossDenied": "无权跨租户访问数据",
+	})
+	apperr.RegisterMessages(apperr.LangEn, map[string]string{
+		"tenant.required":    "Tenant is required",
+		"tenant.crossDenied": "Cross-tenant access denied",
+	})
+}
+
+// TenantContext 以 Jwtware 写入的 tenantId 构造访问数据库用的context，配合 database.Scoped 使用
+// 该租户已在签发token及 Jwtware 中校验用户是否属于该租户，见 checkTenant
+func TenantContext(ctx *fiber.Ctx) context.Context {
+	c := ctx.UserContext()
+	if tenantId, _ := ctx.Locals("tenantId").(string); tenantId != "" {
+		c = database.WithTenant(c, tenantId)
+	}
+	return c
+}
+
+// CrossTenant 当前请求允许跨租户访问数据，仅超级管理员可用，其他用户返回 apperr.ErrReject
+func CrossTenant(ctx *fiber.Ctx) error {
+	subject, _ := ctx.Locals("userId").(string)
+	if subject == "" {
+		return apperr.ErrUnauthorized
+	}
+	isSuperAdmin, err := authorization.IsSuperAdmin(subject)
+	if err != nil {
+		return apperr.ErrService.WithCause(err)
+	}
+	if !isSuperAdmin {
+		return apperr.ErrReject.WithMsg("tenant.crossDenied")
+	}
+	ctx.SetUserContext(database.WithCrossTenant(ctx.UserContext()))
+	return nil
+}
+
+// RequireCrossTenant 以中间件方式调用 CrossTenant，用于超级管理员的跨租户管理接口
+func RequireCrossTenant() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if err := CrossTenant(ctx); err != nil {
+			return err
+		}
+		return ctx.Next()
+	}
+}
+
+// tenantError 缺少租户时拒绝访问，其他错误为服务错误
+func tenantError(err error) error {
+	if errors.Is(err, database.ErrTenantRequired) {
+		return apperr.ErrReject.WithMsg("tenant.required").WithCause(err)
+	}
+	return apperr.ErrService.WithCause(err)
+}
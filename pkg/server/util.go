@@ -2,18 +2,44 @@ package server
 
 import (
 	"strconv"
-	"strings"
 
 	"github.com/gofiber/fiber/v2"
 
-	"github.com/yockii/qscore/pkg/util"
+	"github.com/yockii/qscore/pkg/apperr"
+	"github.com/yockii/qscore/pkg/validator"
 )
 
 func NoNeed(ctx *fiber.Ctx) error {
 	return ctx.SendStatus(fiber.StatusNotFound)
 }
 
-func ParsePaginationInfoFromQuery(ctx *fiber.Ctx) (limit, offset int, orderBy string, err error) {
+// ParseBody 解析请求体并按 validate 标签校验，scene为校验场景，如 validator.SceneAdd
+// 校验失败时返回的错误携带全部不合法字段
+func ParseBody(ctx *fiber.Ctx, out interface{}, scene ...string) error {
+	if err := ctx.BodyParser(out); err != nil {
+		return apperr.ErrBodyParse.WithCause(err)
+	}
+	return validator.Check(out, firstScene(scene))
+}
+
+// ParseQuery 解析查询参数并按 validate 标签校验
+func ParseQuery(ctx *fiber.Ctx, out interface{}, scene ...string) error {
+	if err := ctx.QueryParser(out); err != nil {
+		return apperr.ErrBodyParse.WithCause(err)
+	}
+	return validator.Check(out, firstScene(scene))
+}
+
+func firstScene(scene []string) string {
+	if len(scene) > 0 {
+		return scene[0]
+	}
+	return ""
+}
+
+// ParsePaginationInfoFromQuery 解析 limit、offset 及 orderBy，排序字段仅允许 model 中标记了 filter:"sort" 的字段，见 ParseOrderBy
+// 未传入model时不支持排序，传入orderBy将返回错误
+func ParsePaginationInfoFromQuery(ctx *fiber.Ctx, model ...interface{}) (limit, offset int, orderBy string, err error) {
 	sizeStr := ctx.Query("limit", "10")
 	offsetStr := ctx.Query("offset", "0")
 	limit, err = strconv.Atoi(sizeStr)
@@ -31,24 +57,39 @@ func ParsePaginationInfoFromQuery(ctx *fiber.Ctx) (limit, offset int, orderBy st
 		offset = 0
 	}
 	orderBy = ctx.Query("orderBy") // orderBy=xxx-desc,yyy-asc,zzz
-	if orderBy != "" {
-		obs := strings.Split(orderBy, ",")
-		ob := ""
-		for _, s := range obs {
-			kds := strings.Split(s, "-")
-			ob += ", " + util.SnakeString(strings.TrimSpace(kds[0]))
-			if len(kds) == 2 {
-				d := strings.ToLower(kds[1])
-				if d == "desc" {
-					ob += " DESC"
-				}
-			}
-		}
-		orderBy = ob[1:]
+	if orderBy == "" {
+		return
 	}
+	if len(model) == 0 {
+		err = apperr.ErrInvalid.WithMsg("filter.invalidSort", orderBy)
+		return
+	}
+	orderBy, err = ParseOrderBy(orderBy, model[0])
 	return
 }
 
+// ParseCursorInfoFromQuery 解析游标分页参数 limit、cursor及count，count=true时统计总数
+// cursor为上一次返回的 next 或 prev，为空时从第一页开始
+func ParseCursorInfoFromQuery(ctx *fiber.Ctx) (*CursorInfo, error) {
+	limit, err := strconv.Atoi(ctx.Query("limit", "10"))
+	if err != nil {
+		return nil, apperr.ErrInvalid.WithCause(err)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 10
+	}
+	info := &CursorInfo{
+		Limit:     limit,
+		WithCount: ctx.Query("count") == "true",
+	}
+	if cursor := ctx.Query("cursor"); cursor != "" {
+		if info.Cursor, err = DecodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
 func GetClientIp(ctx *fiber.Ctx) string {
 	ip := ctx.IP()
 	if ips := ctx.IPs(); len(ips) > 0 {
@@ -0,0 +1,262 @@
+package session
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/yockii/qscore/pkg/cache"
+	"github.com/yockii/qscore/pkg/config"
+	"github.com/yockii/qscore/pkg/constant"
+	"github.com/yockii/qscore/pkg/domain"
+)
+
+// 缓存结构：
+//  prefix:sid:<sid>          -> uid，Jwtware据此校验token
+//  prefix:sid:<sid>:refresh  -> 当前有效的refresh token id
+//  prefix:sid:<sid>:info     -> 会话信息hash
+//  prefix:userSid:<uid>      -> 用户的sid集合(zset，score为创建时间)
+
+const (
+	RotateOk      = 1
+	RotateMissing = 0
+	RotateReused  = -1
+)
+
+var ErrCacheDisabled = errors.New("缓存未启用，无法管理会话")
+
+type Session struct {
+	Sid        string          `json:"sid"`
+	UserId     string          `json:"userId"`
+	TenantId   string          `json:"tenantId,omitempty"`
+	Ip         string          `json:"ip,omitempty"`
+	UserAgent  string          `json:"userAgent,omitempty"`
+	CreateTime domain.DateTime `json:"createTime"`
+}
+
+// rotateScript 原子地校验并轮换refresh token id，同时顺延会话有效期
+// 返回 RotateOk / RotateMissing / RotateReused，检测到重放时删除会话
+var rotateScript = redis.NewScript(4, `
+local current = redis.call('GET', KEYS[2])
+if not current then
+	return 0
+end
+if current ~= ARGV[1] then
+	redis.call('DEL', KEYS[1], KEYS[2], KEYS[3])
+	redis.call('ZREM', KEYS[4], ARGV[4])
+	return -1
+end
+redis.call('SET', KEYS[2], ARGV[2], 'EX', ARGV[3])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('EXPIRE', KEYS[3], ARGV[3])
+redis.call('EXPIRE', KEYS[4], ARGV[3])
+return 1
+`)
+
+// MaxPerUser 单用户最大并发会话数，配置项 session.maxPerUser，0表示不限制
+func MaxPerUser() int {
+	return config.GetInt("session.maxPerUser")
+}
+
+func sidKey(sid string) string {
+	return cache.Prefix + ":" + constant.AppSid + ":" + sid
+}
+func refreshKey(sid string) string {
+	return sidKey(sid) + ":refresh"
+}
+func infoKey(sid string) string {
+	return sidKey(sid) + ":info"
+}
+func userKey(uid string) string {
+	return cache.Prefix + ":userSid:" + uid
+}
+
+// Create 记录新会话，超出单用户会话上限时注销最早的会话
+func Create(s *Session, refreshId string, ttl time.Duration) error {
+	if !cache.Enabled() {
+		return nil
+	}
+	if time.Time(s.CreateTime).IsZero() {
+		s.CreateTime = domain.DateTime(time.Now())
+	}
+	seconds := int64(ttl / time.Second)
+	createTime := time.Time(s.CreateTime)
+
+	rConn := cache.Get()
+	defer rConn.Close()
+	_ = rConn.Send("MULTI")
+	_ = rConn.Send("SET", sidKey(s.Sid), s.UserId, "EX", seconds)
+	_ = rConn.Send("SET", refreshKey(s.Sid), refreshId, "EX", seconds)
+	_ = rConn.Send("HSET", infoKey(s.Sid),
+		"userId", s.UserId,
+		"tenantId", s.TenantId,
+		"ip", s.Ip,
+		"userAgent", s.UserAgent,
+		"createTime", createTime.Unix(),
+	)
+	_ = rConn.Send("EXPIRE", infoKey(s.Sid), seconds)
+	_ = rConn.Send("ZADD", userKey(s.UserId), createTime.UnixNano(), s.Sid)
+	_ = rConn.Send("EXPIRE", userKey(s.UserId), seconds)
+	if _, err := rConn.Do("EXEC"); err != nil {
+		return err
+	}
+
+	if max := MaxPerUser(); max > 0 {
+		if err := prune(rConn, s.UserId); err != nil {
+			return err
+		}
+		sids, err := redis.Strings(rConn.Do("ZRANGE", userKey(s.UserId), 0, -1))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(sids)-max; i++ {
+			if err = revoke(rConn, sids[i], s.UserId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UserId 获取会话对应的用户ID，会话不存在时返回 redis.ErrNil
+func UserId(sid string) (string, error) {
+	rConn := cache.Get()
+	defer rConn.Close()
+	return redis.String(rConn.Do("GET", sidKey(sid)))
+}
+
+// Rotate 轮换会话的refresh token id，缓存未启用时无法检测重放，返回 ErrCacheDisabled
+func Rotate(sid, uid, oldRefreshId, newRefreshId string, ttl time.Duration) (int, error) {
+	if !cache.Enabled() {
+		return RotateMissing, ErrCacheDisabled
+	}
+	rConn := cache.Get()
+	defer rConn.Close()
+	return redis.Int(rotateScript.Do(rConn,
+		sidKey(sid), refreshKey(sid), infoKey(sid), userKey(uid),
+		oldRefreshId, newRefreshId, int64(ttl/time.Second), sid,
+	))
+}
+
+func Get(sid string) (*Session, error) {
+	if !cache.Enabled() {
+		return nil, ErrCacheDisabled
+	}
+	rConn := cache.Get()
+	defer rConn.Close()
+	return get(rConn, sid)
+}
+
+func get(rConn redis.Conn, sid string) (*Session, error) {
+	values, err := redis.StringMap(rConn.Do("HGETALL", infoKey(sid)))
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	s := &Session{
+		Sid:       sid,
+		UserId:    values["userId"],
+		TenantId:  values["tenantId"],
+		Ip:        values["ip"],
+		UserAgent: values["userAgent"],
+	}
+	if ts, err := strconv.ParseInt(values["createTime"], 10, 64); err == nil {
+		s.CreateTime = domain.DateTime(time.Unix(ts, 0))
+	}
+	return s, nil
+}
+
+// ListByUser 列出用户当前有效的会话，按创建时间升序
+func ListByUser(uid string) ([]*Session, error) {
+	if !cache.Enabled() {
+		return nil, ErrCacheDisabled
+	}
+	rConn := cache.Get()
+	defer rConn.Close()
+	if err := prune(rConn, uid); err != nil {
+		return nil, err
+	}
+	sids, err := redis.Strings(rConn.Do("ZRANGE", userKey(uid), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, 0, len(sids))
+	for _, sid := range sids {
+		s, err := get(rConn, sid)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+// Revoke 注销单个会话
+func Revoke(sid string) error {
+	if !cache.Enabled() || sid == "" {
+		return nil
+	}
+	rConn := cache.Get()
+	defer rConn.Close()
+	uid, err := redis.String(rConn.Do("GET", sidKey(sid)))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	return revoke(rConn, sid, uid)
+}
+
+// RevokeUser 注销用户的全部会话，返回注销的会话数
+func RevokeUser(uid string) (int, error) {
+	if !cache.Enabled() {
+		return 0, ErrCacheDisabled
+	}
+	rConn := cache.Get()
+	defer rConn.Close()
+	sids, err := redis.Strings(rConn.Do("ZRANGE", userKey(uid), 0, -1))
+	if err != nil {
+		return 0, err
+	}
+	for _, sid := range sids {
+		if err = revoke(rConn, sid, uid); err != nil {
+			return 0, err
+		}
+	}
+	_, err = rConn.Do("DEL", userKey(uid))
+	return len(sids), err
+}
+
+func revoke(rConn redis.Conn, sid, uid string) error {
+	_ = rConn.Send("MULTI")
+	_ = rConn.Send("DEL", sidKey(sid), refreshKey(sid), infoKey(sid))
+	if uid != "" {
+		_ = rConn.Send("ZREM", userKey(uid), sid)
+	}
+	_, err := rConn.Do("EXEC")
+	return err
+}
+
+// prune 清理索引中已过期的sid
+func prune(rConn redis.Conn, uid string) error {
+	sids, err := redis.Strings(rConn.Do("ZRANGE", userKey(uid), 0, -1))
+	if err != nil {
+		return err
+	}
+	for _, sid := range sids {
+		exists, err := redis.Bool(rConn.Do("EXISTS", sidKey(sid)))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if _, err = rConn.Do("ZREM", userKey(uid), sid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/yockii/qscore/pkg/config"
+)
+
+// 认证加密工具，密文格式为 密钥版本(1字节) + nonce + 密文及tag，再进行base64编码
+// 密钥轮换后旧版本密钥保留在密钥环中，旧数据仍可解密，新数据使用当前版本加密
+
+const (
+	AeadAesGcm           = "aes-gcm"
+	AeadChaCha20Poly1305 = "chacha20-poly1305"
+)
+
+var (
+	ErrAeadKeyNotFound   = errors.New("未找到对应版本的加密密钥")
+	ErrAeadCipherInvalid = errors.New("密文格式非法")
+)
+
+// AeadKey 对应配置项 crypto.aead.keys 的元素，key为base64编码的密钥
+type AeadKey struct {
+	Version   uint8  `mapstructure:"version"`
+	Algorithm string `mapstructure:"algorithm"`
+	Key       string `mapstructure:"key"`
+}
+
+type AeadKeyRing struct {
+	lock    sync.RWMutex
+	current uint8
+	aeads   map[uint8]cipher.AEAD
+}
+
+func NewAeadKeyRing(current uint8, keys ...AeadKey) (*AeadKeyRing, error) {
+	r := &AeadKeyRing{
+		aeads: make(map[uint8]cipher.AEAD),
+	}
+	for _, k := range keys {
+		if err := r.AddKey(k); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.SetCurrent(current); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadAeadKeyRingFromConfig 读取配置项 <key>.current 及 <key>.keys
+func LoadAeadKeyRingFromConfig(key string) (*AeadKeyRing, error) {
+	var keys []AeadKey
+	if err := config.UnmarshalKey(key+".keys", &keys); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("未配置加密密钥 %s.keys", key)
+	}
+	current := uint8(config.GetUint(key + ".current"))
+	if !config.IsSet(key + ".current") {
+		current = keys[len(keys)-1].Version
+	}
+	return NewAeadKeyRing(current, keys...)
+}
+
+func (r *AeadKeyRing) AddKey(k AeadKey) error {
+	key, err := base64.StdEncoding.DecodeString(k.Key)
+	if err != nil {
+		return fmt.Errorf("密钥[%d]不是合法的base64: %v", k.Version, err)
+	}
+	var aead cipher.AEAD
+	switch k.Algorithm {
+	case AeadAesGcm, "":
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("密钥[%d]: %v", k.Version, err)
+		}
+		if aead, err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	case AeadChaCha20Poly1305:
+		if aead, err = chacha20poly1305.New(key); err != nil {
+			return fmt.Errorf("密钥[%d]: %v", k.Version, err)
+		}
+	default:
+		return fmt.Errorf("密钥[%d]使用了不支持的算法 %s", k.Version, k.Algorithm)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.aeads[k.Version] = aead
+	return nil
+}
+
+func (r *AeadKeyRing) SetCurrent(version uint8) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.aeads[version]; !ok {
+		return ErrAeadKeyNotFound
+	}
+	r.current = version
+	return nil
+}
+
+// Encrypt additional为附加认证数据，解密时需传入相同的值
+func (r *AeadKeyRing) Encrypt(plain, additional []byte) ([]byte, error) {
+	r.lock.RLock()
+	version := r.current
+	aead := r.aeads[version]
+	r.lock.RUnlock()
+
+	out := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(plain)+aead.Overhead())
+	out[0] = version
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out[1:], plain, additional), nil
+}
+
+func (r *AeadKeyRing) Decrypt(data, additional []byte) ([]byte, error) {
+	if len(data) < 1 {
+		return nil, ErrAeadCipherInvalid
+	}
+	r.lock.RLock()
+	aead, ok := r.aeads[data[0]]
+	r.lock.RUnlock()
+	if !ok {
+		return nil, ErrAeadKeyNotFound
+	}
+	if len(data) < 1+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrAeadCipherInvalid
+	}
+	nonce := data[1 : 1+aead.NonceSize()]
+	return aead.Open(nil, nonce, data[1+aead.NonceSize():], additional)
+}
+
+func (r *AeadKeyRing) EncryptBase64String(src string) (string, error) {
+	rst, err := r.Encrypt([]byte(src), nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(rst), nil
+}
+
+func (r *AeadKeyRing) DecryptBase64String(src string) (string, error) {
+	bs, err := base64.StdEncoding.DecodeString(src)
+	if err != nil {
+		return "", err
+	}
+	rst, err := r.Decrypt(bs, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(rst), nil
+}
+
+// KeyVersion 获取密文使用的密钥版本，可用于判断数据是否需要使用新密钥重新加密
+func (r *AeadKeyRing) KeyVersion(src string) (uint8, error) {
+	bs, err := base64.StdEncoding.DecodeString(src)
+	if err != nil {
+		return 0, err
+	}
+	if len(bs) < 1 {
+		return 0, ErrAeadCipherInvalid
+	}
+	return bs[0], nil
+}
+
+func (r *AeadKeyRing) Current() uint8 {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.current
+}
+
+/////////////////////////////////////////////////////////////////////
+//////// 默认密钥环，配置项 crypto.aead //////
+
+var (
+	defaultAeadKeyRing *AeadKeyRing
+	defaultAeadLock    sync.Mutex
+)
+
+// InitAeadKeyRing 从配置项 crypto.aead 重新加载默认密钥环，可用于密钥轮换
+func InitAeadKeyRing() error {
+	r, err := LoadAeadKeyRingFromConfig("crypto.aead")
+	if err != nil {
+		return err
+	}
+	defaultAeadLock.Lock()
+	defaultAeadKeyRing = r
+	defaultAeadLock.Unlock()
+	return nil
+}
+
+func SetAeadKeyRing(r *AeadKeyRing) {
+	defaultAeadLock.Lock()
+	defaultAeadKeyRing = r
+	defaultAeadLock.Unlock()
+}
+
+// DefaultAeadKeyRing 首次使用时从配置加载
+func DefaultAeadKeyRing() (*AeadKeyRing, error) {
+	defaultAeadLock.Lock()
+	r := defaultAeadKeyRing
+	defaultAeadLock.Unlock()
+	if r != nil {
+		return r, nil
+	}
+	if err := InitAeadKeyRing(); err != nil {
+		return nil, err
+	}
+	return DefaultAeadKeyRing()
+}
+
+func AeadEncryptBase64String(src string) (string, error) {
+	r, err := DefaultAeadKeyRing()
+	if err != nil {
+		return "", err
+	}
+	return r.EncryptBase64String(src)
+}
+
+func AeadDecryptBase64String(src string) (string, error) {
+	r, err := DefaultAeadKeyRing()
+	if err != nil {
+		return "", err
+	}
+	return r.DecryptBase64String(src)
+}
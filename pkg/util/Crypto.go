@@ -1,11 +1,19 @@
 package util
 
 import (
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/forgoer/openssl"
+
+	"github.com/yockii/qscore/pkg/config"
 )
 
 func Md5(str string) string {
@@ -34,3 +42,50 @@ func Des3EcbPkcs5PaddingDecryptBase64String(src string, key []byte) (string, err
 	}
 	return string(rst), nil
 }
+
+// minBlindIndexKeyLength 盲索引密钥的最小长度(字节)
+const minBlindIndexKeyLength = 16
+
+var (
+	blindIndexKey  []byte
+	blindIndexSm3  bool
+	blindIndexLock sync.RWMutex
+)
+
+// InitBlindIndex 从配置项 crypto.blindIndex.key(base64，至少16字节) 及 crypto.blindIndex.algorithm 加载盲索引密钥，
+// 建议启动时调用以尽早发现配置错误，未调用时在第一次 BlindIndex 时加载；更换配置文件后可重新调用
+func InitBlindIndex() error {
+	key, err := base64.StdEncoding.DecodeString(config.GetString("crypto.blindIndex.key"))
+	if err != nil {
+		return errors.New("盲索引密钥 crypto.blindIndex.key 不是合法的base64")
+	}
+	if len(key) < minBlindIndexKeyLength {
+		return fmt.Errorf("盲索引密钥 crypto.blindIndex.key 未配置或长度不足%d字节", minBlindIndexKeyLength)
+	}
+	blindIndexLock.Lock()
+	defer blindIndexLock.Unlock()
+	blindIndexKey = key
+	blindIndexSm3 = strings.ToLower(config.GetString("crypto.blindIndex.algorithm")) == "sm3"
+	return nil
+}
+
+// BlindIndex 计算等值查询用的盲索引(HMAC)，用于加密字段的精确匹配
+// 配置项 crypto.blindIndex.key 为base64编码的密钥，crypto.blindIndex.algorithm 可选 sha256(默认) / sm3
+// 密钥未加载时从配置加载，未配置时返回错误，不会使用空密钥计算
+func BlindIndex(value string) (string, error) {
+	blindIndexLock.RLock()
+	key, useSm3 := blindIndexKey, blindIndexSm3
+	blindIndexLock.RUnlock()
+	if len(key) == 0 {
+		if err := InitBlindIndex(); err != nil {
+			return "", err
+		}
+		return BlindIndex(value)
+	}
+	if useSm3 {
+		return Sm3Hmac(value, key), nil
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(value))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
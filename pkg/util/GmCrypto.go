@@ -0,0 +1,271 @@
+package util
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/sm3"
+	"github.com/tjfoc/gmsm/sm4"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+// 国密算法工具，SM2密文采用C1C3C2格式
+
+func Sm3(str string) string {
+	return hex.EncodeToString(sm3.Sm3Sum([]byte(str)))
+}
+
+func Sm3Hmac(str string, key []byte) string {
+	h := hmac.New(sm3.New, key)
+	h.Write([]byte(str))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+/////////////////////////////////////////////////////////////////////
+//////// SM2 //////
+
+func GenerateSm2Key() (*sm2.PrivateKey, error) {
+	return sm2.GenerateKey(rand.Reader)
+}
+
+// Sm2PrivateKeyToPem pwd为空时输出不加密的PEM
+func Sm2PrivateKeyToPem(key *sm2.PrivateKey, pwd []byte) ([]byte, error) {
+	return x509.WritePrivateKeyToPem(key, pwd)
+}
+
+func Sm2PrivateKeyFromPem(pem, pwd []byte) (*sm2.PrivateKey, error) {
+	return x509.ReadPrivateKeyFromPem(pem, pwd)
+}
+
+func Sm2PublicKeyToPem(key *sm2.PublicKey) ([]byte, error) {
+	return x509.WritePublicKeyToPem(key)
+}
+
+func Sm2PublicKeyFromPem(pem []byte) (*sm2.PublicKey, error) {
+	return x509.ReadPublicKeyFromPem(pem)
+}
+
+func Sm2SignBase64String(src string, key *sm2.PrivateKey) (string, error) {
+	sign, err := key.Sign(rand.Reader, []byte(src), nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sign), nil
+}
+
+func Sm2VerifyBase64String(src, sign string, key *sm2.PublicKey) bool {
+	bs, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return false
+	}
+	return key.Verify([]byte(src), bs)
+}
+
+func Sm2EncryptBase64String(src string, key *sm2.PublicKey) (string, error) {
+	rst, err := sm2.Encrypt(key, []byte(src), rand.Reader, sm2.C1C3C2)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(rst), nil
+}
+
+func Sm2DecryptBase64String(src string, key *sm2.PrivateKey) (string, error) {
+	bs, err := base64.StdEncoding.DecodeString(src)
+	if err != nil {
+		return "", err
+	}
+	rst, err := sm2.Decrypt(key, bs, sm2.C1C3C2)
+	if err != nil {
+		return "", err
+	}
+	return string(rst), nil
+}
+
+func Sm2EncryptHexString(src string, key *sm2.PublicKey) (string, error) {
+	rst, err := sm2.Encrypt(key, []byte(src), rand.Reader, sm2.C1C3C2)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(rst), nil
+}
+
+func Sm2DecryptHexString(src string, key *sm2.PrivateKey) (string, error) {
+	bs, err := hex.DecodeString(src)
+	if err != nil {
+		return "", err
+	}
+	rst, err := sm2.Decrypt(key, bs, sm2.C1C3C2)
+	if err != nil {
+		return "", err
+	}
+	return string(rst), nil
+}
+
+/////////////////////////////////////////////////////////////////////
+//////// SM4 //////
+
+// Sm4KeyToPem pwd为空时输出不加密的PEM
+func Sm4KeyToPem(key, pwd []byte) ([]byte, error) {
+	return sm4.WriteKeyToPem(key, pwd)
+}
+
+func Sm4KeyFromPem(pem, pwd []byte) ([]byte, error) {
+	return sm4.ReadKeyFromPem(pem, pwd)
+}
+
+func Sm4CbcPkcs7PaddingEncryptBase64String(src string, key, iv []byte) (string, error) {
+	rst, err := sm4CbcEncrypt([]byte(src), key, iv)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(rst), nil
+}
+
+func Sm4CbcPkcs7PaddingDecryptBase64String(src string, key, iv []byte) (string, error) {
+	bs, err := base64.StdEncoding.DecodeString(src)
+	if err != nil {
+		return "", err
+	}
+	rst, err := sm4CbcDecrypt(bs, key, iv)
+	if err != nil {
+		return "", err
+	}
+	return string(rst), nil
+}
+
+func Sm4CbcPkcs7PaddingEncryptHexString(src string, key, iv []byte) (string, error) {
+	rst, err := sm4CbcEncrypt([]byte(src), key, iv)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(rst), nil
+}
+
+func Sm4CbcPkcs7PaddingDecryptHexString(src string, key, iv []byte) (string, error) {
+	bs, err := hex.DecodeString(src)
+	if err != nil {
+		return "", err
+	}
+	rst, err := sm4CbcDecrypt(bs, key, iv)
+	if err != nil {
+		return "", err
+	}
+	return string(rst), nil
+}
+
+// Sm4GcmEncryptBase64String 随机生成12字节nonce，结果为 nonce+密文+tag
+func Sm4GcmEncryptBase64String(src string, key []byte) (string, error) {
+	rst, err := sm4GcmEncrypt([]byte(src), key)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(rst), nil
+}
+
+func Sm4GcmDecryptBase64String(src string, key []byte) (string, error) {
+	bs, err := base64.StdEncoding.DecodeString(src)
+	if err != nil {
+		return "", err
+	}
+	rst, err := sm4GcmDecrypt(bs, key)
+	if err != nil {
+		return "", err
+	}
+	return string(rst), nil
+}
+
+func Sm4GcmEncryptHexString(src string, key []byte) (string, error) {
+	rst, err := sm4GcmEncrypt([]byte(src), key)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(rst), nil
+}
+
+func Sm4GcmDecryptHexString(src string, key []byte) (string, error) {
+	bs, err := hex.DecodeString(src)
+	if err != nil {
+		return "", err
+	}
+	rst, err := sm4GcmDecrypt(bs, key)
+	if err != nil {
+		return "", err
+	}
+	return string(rst), nil
+}
+
+func sm4CbcEncrypt(src, key, iv []byte) ([]byte, error) {
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, errors.New("SM4 iv长度必须为16字节")
+	}
+	padding := block.BlockSize() - len(src)%block.BlockSize()
+	data := append(append([]byte{}, src...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data, nil
+}
+
+func sm4CbcDecrypt(src, key, iv []byte) ([]byte, error) {
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, errors.New("SM4 iv长度必须为16字节")
+	}
+	if len(src) == 0 || len(src)%block.BlockSize() != 0 {
+		return nil, errors.New("SM4 密文长度非法")
+	}
+	data := make([]byte, len(src))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, src)
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > block.BlockSize() {
+		return nil, errors.New("SM4 填充非法")
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("SM4 填充非法")
+		}
+	}
+	return data[:len(data)-padding], nil
+}
+
+func sm4GcmEncrypt(src, key []byte) ([]byte, error) {
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, src, nil), nil
+}
+
+func sm4GcmDecrypt(src, key []byte) ([]byte, error) {
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(src) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, errors.New("SM4 密文长度非法")
+	}
+	return gcm.Open(nil, src[:gcm.NonceSize()], src[gcm.NonceSize():], nil)
+}
@@ -11,12 +11,19 @@ import (
 
 var CstZone = time.FixedZone("CST", 8*3600) // 东八
 
+// databaseIdLength ksuid字符串长度
+const databaseIdLength = 27
+
 func GenerateDatabaseID() string {
 	uid := ksuid.New()
 	return uid.String()
 }
 
+// GetTimeFromDatabaseId id可以带有前缀，如 domain.UserIdPrefix
 func GetTimeFromDatabaseId(id string) (t time.Time, err error) {
+	if len(id) > databaseIdLength {
+		id = id[len(id)-databaseIdLength:]
+	}
 	kid, err := ksuid.Parse(id)
 	if err != nil {
 		return
@@ -0,0 +1,392 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yockii/qscore/pkg/apperr"
+)
+
+// 基于结构体标签的参数校验，标签格式：
+//
+//	validate:"required,min=2,max=50,enum=a|b|c,eqfield=Password,regex=^[a-z]+$"
+//
+// 规则以逗号分隔，regex必须是最后一条规则(其后内容均视为正则表达式)
+// 规则可以加 @场景 后缀，仅在对应场景下校验，如 required@add 只在新增时要求必填
+// 除required/requiredWith外，字段为零值时跳过其余规则
+// 支持的规则：
+//
+//	required              必填
+//	requiredWith=Field    Field非零值时必填
+//	min=N / max=N / len=N 字符串为字符数，切片为元素个数，数字为数值
+//	enum=a|b|c            取值范围
+//	regex=...             正则匹配，仅用于字符串
+//	eqfield / nefield / gtfield / gtefield / ltfield / ltefield=Field  与同级字段比较，支持数字、字符串及时间
+
+const (
+	SceneAdd    = "add"
+	SceneUpdate = "update"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type rule struct {
+	name  string
+	param string
+	scene string
+	// display 错误消息中展示的参数，字段比较规则为被比较字段的json名
+	display string
+	re      *regexp.Regexp
+}
+
+type fieldRules struct {
+	index []int
+	name  string
+	rules []*rule
+	// nested 需要递归校验的结构体字段
+	nested bool
+}
+
+var cache sync.Map // reflect.Type -> []*fieldRules
+
+// FieldError 单个字段的校验错误
+type FieldError struct {
+	Field string
+	Rule  string
+	Param string
+}
+
+// FieldMessage 返回给调用方的字段错误
+type FieldMessage struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Msg   string `json:"msg"`
+}
+
+type Errors []*FieldError
+
+func (errs Errors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, m := range errs.Localize(apperr.DefaultLang).([]*FieldMessage) {
+		msgs = append(msgs, m.Msg)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Localize 实现 apperr.Localizer，按语言生成字段错误消息
+func (errs Errors) Localize(lang string) interface{} {
+	msgs := make([]*FieldMessage, 0, len(errs))
+	for _, e := range errs {
+		var msg string
+		if e.Param != "" {
+			msg = apperr.Message(lang, "validate."+e.Rule, e.Field, e.Param)
+		} else {
+			msg = apperr.Message(lang, "validate."+e.Rule, e.Field)
+		}
+		msgs = append(msgs, &FieldMessage{Field: e.Field, Rule: e.Rule, Msg: msg})
+	}
+	return msgs
+}
+
+// OnlyRequired 是否所有错误都是必填校验失败
+func (errs Errors) OnlyRequired() bool {
+	for _, e := range errs {
+		if e.Rule != "required" && e.Rule != "requiredWith" {
+			return false
+		}
+	}
+	return true
+}
+
+// Struct 校验结构体，scene为空时只校验不带场景的规则
+func Struct(v interface{}, scene string) Errors {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	var errs Errors
+	validateStruct(rv, scene, "", &errs)
+	return errs
+}
+
+// Check 校验结构体，失败时返回携带字段错误的 *apperr.Error
+func Check(v interface{}, scene string) error {
+	errs := Struct(v, scene)
+	if len(errs) == 0 {
+		return nil
+	}
+	e := apperr.ErrInvalid
+	if errs.OnlyRequired() {
+		e = apperr.ErrLackOfField
+	}
+	return e.WithMsg("validate.failed").WithData(errs)
+}
+
+func validateStruct(rv reflect.Value, scene, prefix string, errs *Errors) {
+	for _, fr := range typeRules(rv.Type()) {
+		fv := rv.FieldByIndex(fr.index)
+		name := prefix + fr.name
+		for _, r := range fr.rules {
+			if r.scene != "" && r.scene != scene {
+				continue
+			}
+			if ok := check(rv, fv, r); !ok {
+				*errs = append(*errs, &FieldError{Field: name, Rule: r.name, Param: r.display})
+				break
+			}
+		}
+		if fr.nested {
+			nv := fv
+			if nv.Kind() == reflect.Ptr {
+				if nv.IsNil() {
+					continue
+				}
+				nv = nv.Elem()
+			}
+			validateStruct(nv, scene, name+".", errs)
+		}
+	}
+}
+
+func typeRules(t reflect.Type) []*fieldRules {
+	if cached, ok := cache.Load(t); ok {
+		return cached.([]*fieldRules)
+	}
+	var frs []*fieldRules
+	collectRules(t, nil, &frs)
+	cache.Store(t, frs)
+	return frs
+}
+
+func collectRules(t reflect.Type, index []int, frs *[]*fieldRules) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		idx := append(append([]int{}, index...), i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			collectRules(f.Type, idx, frs)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		fr := &fieldRules{index: idx, name: fieldName(f)}
+		if tag, ok := f.Tag.Lookup("validate"); ok && tag != "-" {
+			fr.rules = parseRules(t, f, tag)
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && !ft.ConvertibleTo(timeType) {
+			fr.nested = true
+		}
+		if len(fr.rules) > 0 || fr.nested {
+			*frs = append(*frs, fr)
+		}
+	}
+}
+
+func fieldName(f reflect.StructField) string {
+	if tag := f.Tag.Get("json"); tag != "" {
+		if name := strings.Split(tag, ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func parseRules(t reflect.Type, f reflect.StructField, tag string) []*rule {
+	var rules []*rule
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, "regex=") {
+			part, tag = tag, ""
+		} else if i := strings.IndexByte(tag, ','); i >= 0 {
+			part, tag = tag[:i], tag[i+1:]
+		} else {
+			part, tag = tag, ""
+		}
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		r := &rule{}
+		if !strings.HasPrefix(part, "regex=") {
+			if i := strings.LastIndexByte(part, '@'); i >= 0 {
+				part, r.scene = part[:i], part[i+1:]
+			}
+		}
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			r.name, r.param = part[:i], part[i+1:]
+		} else {
+			r.name = part
+		}
+		switch r.name {
+		case "regex":
+			r.re = regexp.MustCompile(r.param)
+		case "eqfield", "nefield", "gtfield", "gtefield", "ltfield", "ltefield", "requiredWith":
+			other, ok := t.FieldByName(r.param)
+			if !ok {
+				panic(fmt.Sprintf("validator: %s.%s 规则 %s 引用的字段 %s 不存在", t.Name(), f.Name, r.name, r.param))
+			}
+			r.display = fieldName(other)
+		case "min", "max", "len", "enum":
+			r.display = r.param
+		case "required":
+		default:
+			panic(fmt.Sprintf("validator: %s.%s 不支持的规则 %s", t.Name(), f.Name, r.name))
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func check(parent, fv reflect.Value, r *rule) bool {
+	switch r.name {
+	case "required":
+		return !isZero(fv)
+	case "requiredWith":
+		return isZero(parent.FieldByName(r.param)) || !isZero(fv)
+	}
+	if isZero(fv) {
+		return true
+	}
+	for fv.Kind() == reflect.Ptr {
+		fv = fv.Elem()
+	}
+	switch r.name {
+	case "min", "max", "len":
+		n, err := strconv.ParseFloat(r.param, 64)
+		if err != nil {
+			return false
+		}
+		size, ok := measure(fv)
+		if !ok {
+			return false
+		}
+		switch r.name {
+		case "min":
+			return size >= n
+		case "max":
+			return size <= n
+		default:
+			return size == n
+		}
+	case "enum":
+		s := fmt.Sprint(fv.Interface())
+		for _, option := range strings.Split(r.param, "|") {
+			if s == option {
+				return true
+			}
+		}
+		return false
+	case "regex":
+		return fv.Kind() == reflect.String && r.re.MatchString(fv.String())
+	default:
+		other := parent.FieldByName(r.param)
+		for other.Kind() == reflect.Ptr {
+			if other.IsNil() {
+				return true
+			}
+			other = other.Elem()
+		}
+		if isZero(other) {
+			return true
+		}
+		c, ok := compare(fv, other)
+		if !ok {
+			return false
+		}
+		switch r.name {
+		case "eqfield":
+			return c == 0
+		case "nefield":
+			return c != 0
+		case "gtfield":
+			return c > 0
+		case "gtefield":
+			return c >= 0
+		case "ltfield":
+			return c < 0
+		default:
+			return c <= 0
+		}
+	}
+}
+
+func isZero(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	if v.Type().ConvertibleTo(timeType) {
+		return v.Convert(timeType).Interface().(time.Time).IsZero()
+	}
+	return v.IsZero()
+}
+
+func measure(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// compare 返回 a与b 的比较结果，-1/0/1
+func compare(a, b reflect.Value) (int, bool) {
+	if a.Type().ConvertibleTo(timeType) && b.Type().ConvertibleTo(timeType) {
+		ta := a.Convert(timeType).Interface().(time.Time)
+		tb := b.Convert(timeType).Interface().(time.Time)
+		switch {
+		case ta.Before(tb):
+			return -1, true
+		case ta.After(tb):
+			return 1, true
+		}
+		return 0, true
+	}
+	if a.Kind() == reflect.String && b.Kind() == reflect.String {
+		return strings.Compare(a.String(), b.String()), true
+	}
+	na, ok := measure(a)
+	if !ok || a.Kind() == reflect.Slice || a.Kind() == reflect.Map {
+		return 0, false
+	}
+	nb, ok := measure(b)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case na < nb:
+		return -1, true
+	case na > nb:
+		return 1, true
+	}
+	return 0, true
+}
@@ -0,0 +1,39 @@
+package validator
+
+import "github.com/yockii/qscore/pkg/apperr"
+
+// 校验消息，第一个参数为字段名，第二个参数为规则参数，可通过 apperr.RegisterMessages 覆盖
+func init() {
+	apperr.RegisterMessages(apperr.LangZh, map[string]string{
+		"validate.failed":       "参数校验失败",
+		"validate.required":     "%s不能为空",
+		"validate.requiredWith": "%s在%s有值时不能为空",
+		"validate.min":          "%s不能小于%s",
+		"validate.max":          "%s不能大于%s",
+		"validate.len":          "%s长度必须为%s",
+		"validate.enum":         "%s必须是[%s]中的一个",
+		"validate.regex":        "%s格式不正确",
+		"validate.eqfield":      "%s必须与%s相同",
+		"validate.nefield":      "%s不能与%s相同",
+		"validate.gtfield":      "%s必须大于%s",
+		"validate.gtefield":     "%s不能小于%s",
+		"validate.ltfield":      "%s必须小于%s",
+		"validate.ltefield":     "%s不能大于%s",
+	})
+	apperr.RegisterMessages(apperr.LangEn, map[string]string{
+		"validate.failed":       "Validation failed",
+		"validate.required":     "%s is required",
+		"validate.requiredWith": "%s is required when %s is present",
+		"validate.min":          "%s must be at least %s",
+		"validate.max":          "%s must be at most %s",
+		"validate.len":          "%s must have length %s",
+		"validate.enum":         "%s must be one of [%s]",
+		"validate.regex":        "%s has an invalid format",
+		"validate.eqfield":      "%s must equal %s",
+		"validate.nefield":      "%s must not equal %s",
+		"validate.gtfield":      "%s must be greater than %s",
+		"validate.gtefield":     "%s must not be less than %s",
+		"validate.ltfield":      "%s must be less than %s",
+		"validate.ltefield":     "%s must not be greater than %s",
+	})
+}